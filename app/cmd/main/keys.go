@@ -0,0 +1,104 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/cristalhq/jwt/v3"
+	"github.com/senizdegen/sdu-housing/user-service/internal/config"
+	"github.com/senizdegen/sdu-housing/user-service/pkg/keyring"
+)
+
+const keysUsage = `usage: main keys <command>
+
+commands:
+  list            list the keys of the keyring, the active one is marked with *
+  generate        generate a new retired key with the configured algorithm
+  promote <kid>   make the key kid the active signing key
+  rotate          generate a new key and promote it
+  remove <kid>    remove a retired key
+
+restart the service after changing the keyring`
+
+// runKeys manages the JWT keyring in cfg.KeysDir.
+func runKeys(cfg config.JWT, args []string) error {
+	if len(args) == 0 {
+		return errors.New(keysUsage)
+	}
+
+	switch args[0] {
+	case "list":
+		return listKeys(cfg.KeysDir)
+	case "generate":
+		_, err := generateKey(cfg)
+		return err
+	case "promote":
+		if len(args) != 2 {
+			return errors.New(keysUsage)
+		}
+		return promoteKey(cfg.KeysDir, args[1])
+	case "rotate":
+		kid, err := generateKey(cfg)
+		if err != nil {
+			return err
+		}
+		return promoteKey(cfg.KeysDir, kid)
+	case "remove":
+		if len(args) != 2 {
+			return errors.New(keysUsage)
+		}
+		if err := keyring.Remove(cfg.KeysDir, args[1]); err != nil {
+			return err
+		}
+		fmt.Printf("removed key %s\n", args[1])
+		return nil
+	default:
+		return errors.New(keysUsage)
+	}
+}
+
+func listKeys(dir string) error {
+	keys, err := keyring.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	activeID, _ := keyring.ActiveID(dir)
+
+	for _, key := range keys {
+		marker := " "
+		if key.ID == activeID {
+			marker = "*"
+		}
+		fmt.Fprintf(os.Stdout, "%s %s %s\n", marker, key.ID, key.Algorithm)
+	}
+
+	return nil
+}
+
+func generateKey(cfg config.JWT) (string, error) {
+	alg := jwt.Algorithm(cfg.Algorithm)
+	if alg == jwt.HS256 {
+		return "", fmt.Errorf("keyring is not used in HS256 mode, set jwt.algorithm to RS256 or EdDSA")
+	}
+
+	key, pemBytes, err := keyring.GenerateKey(alg)
+	if err != nil {
+		return "", fmt.Errorf("failed to generate key. error: %w", err)
+	}
+	if err = keyring.WriteKey(cfg.KeysDir, key, pemBytes); err != nil {
+		return "", err
+	}
+
+	fmt.Printf("generated %s key %s\n", key.Algorithm, key.ID)
+	return key.ID, nil
+}
+
+func promoteKey(dir, kid string) error {
+	if err := keyring.Promote(dir, kid); err != nil {
+		return err
+	}
+
+	fmt.Printf("promoted key %s\n", kid)
+	return nil
+}
@@ -12,15 +12,20 @@ import (
 	"syscall"
 	"time"
 
+	"github.com/cristalhq/jwt/v3"
 	"github.com/julienschmidt/httprouter"
 	"github.com/senizdegen/sdu-housing/user-service/internal/config"
+	"github.com/senizdegen/sdu-housing/user-service/internal/password"
 	"github.com/senizdegen/sdu-housing/user-service/internal/user"
 	"github.com/senizdegen/sdu-housing/user-service/internal/user/db"
 	"github.com/senizdegen/sdu-housing/user-service/pkg/cache/freecache"
+	"github.com/senizdegen/sdu-housing/user-service/pkg/handlers/jwks"
 	"github.com/senizdegen/sdu-housing/user-service/pkg/handlers/metric"
+	"github.com/senizdegen/sdu-housing/user-service/pkg/keyring"
 	"github.com/senizdegen/sdu-housing/user-service/pkg/logging"
 	mongo "github.com/senizdegen/sdu-housing/user-service/pkg/mongodb"
 	"github.com/senizdegen/sdu-housing/user-service/pkg/shutdown"
+	"github.com/senizdegen/sdu-housing/user-service/pkg/sms"
 )
 
 func main() {
@@ -28,9 +33,16 @@ func main() {
 	logger := logging.GetLogger()
 	logger.Println("logger initialized")
 
+	if len(os.Args) > 1 && os.Args[1] == "keys" {
+		if err := runKeys(config.GetConfig().JWT, os.Args[2:]); err != nil {
+			logger.Fatal(err)
+		}
+		return
+	}
+
 	logger.Println("config initializing")
+	// the config holds secrets read from the environment, it is not logged
 	cfg := config.GetConfig()
-	logger.Println(cfg)
 
 	logger.Println("router initializing")
 	router := httprouter.New()
@@ -53,26 +65,123 @@ func main() {
 
 	logger.Println("cache initializing")
 	refreshTokenCache := freecache.NewCacheRepo(104857600) // 100MB
+	lockoutCache := freecache.NewCacheRepo(16777216)       // 16MB
+
+	userStorage, err := db.NewStorage(context.Background(), mongoClient, cfg.MongoDB.Collection, logger)
+	if err != nil {
+		logger.Fatal(err)
+	}
+
+	logger.Println("jwt keys initializing")
+	keys, err := newKeyring(cfg.JWT)
+	if err != nil {
+		logger.Fatal(err)
+	}
 
-	userStorage := db.NewStorage(mongoClient, cfg.MongoDB.Collection, logger)
+	jwksHandler := jwks.Handler{Logger: logger, Keyring: keys}
+	jwksHandler.Register(router)
+
+	smsSender, err := newSMSSender(cfg.SMS, logger)
+	if err != nil {
+		logger.Fatal(err)
+	}
+
+	logger.Println("password policy initializing")
+	passwordPolicy, err := password.NewPolicy(cfg.PasswordPolicy)
+	if err != nil {
+		logger.Fatal(err)
+	}
+
+	passwordHasher, err := password.NewHasher(cfg.PasswordHash)
+	if err != nil {
+		logger.Fatal(err)
+	}
 
-	userService, err := user.NewService(userStorage, logger, refreshTokenCache)
+	userService, err := user.NewService(userStorage, logger, refreshTokenCache, lockoutCache, keys, smsSender, passwordPolicy, passwordHasher)
 	if err != nil {
 		logger.Fatal(err)
 	}
 
+	introspectionClients := make(map[string]string, len(cfg.Auth.IntrospectionClients))
+	for _, client := range cfg.Auth.IntrospectionClients {
+		if client.Secret == "" {
+			logger.Warnf("%s is not set, introspection client %s is disabled", client.SecretEnv(), client.ID)
+			continue
+		}
+		introspectionClients[client.ID] = client.Secret
+	}
+
 	usersHandler := user.Handler{
-		Logger:      logger,
-		UserService: userService,
+		Logger:               logger,
+		UserService:          userService,
+		LegacyGetLogin:       cfg.Auth.LegacyGetLogin,
+		IntrospectionClients: introspectionClients,
+		TrustedProxies:       cfg.Lockout.TrustedProxies,
 	}
 
 	usersHandler.Register(router)
 
+	logger.Println("deleted users purger starting")
+	go user.RunPurger(context.Background(), userService, cfg.Deletion.PurgeInterval, logger)
+
 	logger.Println("start application")
 	start(router, logger, cfg)
 
 }
 
+func newKeyring(cfg config.JWT) (*keyring.Keyring, error) {
+	switch alg := jwt.Algorithm(cfg.Algorithm); alg {
+	case jwt.HS256:
+		key, err := keyring.NewHMACKey(alg, []byte(cfg.Secret))
+		if err != nil {
+			return nil, fmt.Errorf("failed to load jwt signing key. error: %w", err)
+		}
+		return keyring.New(key)
+	case jwt.RS256, jwt.EdDSA:
+	default:
+		return nil, fmt.Errorf("unsupported jwt algorithm %q", cfg.Algorithm)
+	}
+
+	keys, err := keyring.LoadDir(cfg.KeysDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load jwt keyring. error: %w", err)
+	}
+	if cfg.Secret == "" {
+		return keys, nil
+	}
+	if cfg.LegacySecretIssuedBefore.IsZero() {
+		return nil, fmt.Errorf("jwt.legacy_secret_issued_before is required to verify tokens with the legacy jwt secret")
+	}
+	if time.Now().After(cfg.LegacySecretIssuedBefore.Add(cfg.AccessTokenTTL)) {
+		// every token issued before the switch has expired
+		return keys, nil
+	}
+
+	// Keep accepting tokens issued in HS256 mode while they are still valid,
+	// but not new ones signed by a leaked secret.
+	legacy, err := keyring.NewHMACKey(jwt.HS256, []byte(cfg.Secret))
+	if err != nil {
+		return nil, fmt.Errorf("failed to load legacy jwt secret. error: %w", err)
+	}
+	legacy.IssuedBefore = cfg.LegacySecretIssuedBefore
+	if err = keys.Add(legacy); err != nil {
+		return nil, err
+	}
+
+	return keys, nil
+}
+
+func newSMSSender(cfg config.SMS, logger logging.Logger) (user.SMSSender, error) {
+	switch cfg.Driver {
+	case "log":
+		return sms.LogSender{Logger: logger}, nil
+	case "file":
+		return sms.NewFileSender(cfg.File)
+	default:
+		return nil, fmt.Errorf("unsupported sms driver %q", cfg.Driver)
+	}
+}
+
 func start(router http.Handler, logger logging.Logger, cfg *config.Config) {
 	var server *http.Server
 	var listener net.Listener
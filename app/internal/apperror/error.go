@@ -3,10 +3,12 @@ package apperror
 import (
 	"encoding/json"
 	"fmt"
+	"time"
 )
 
 var (
-	ErrNotFound = NewAppError("not found", "NS-000010", "")
+	ErrNotFound           = NewAppError("not found", "NS-000010", "")
+	ErrPreconditionFailed = NewAppError("resource was modified, fetch it again and retry", "NS-000006", "If-Match does not match the current ETag")
 )
 
 type AppError struct {
@@ -14,6 +16,10 @@ type AppError struct {
 	Message          string `json:"message,omitempty"`
 	DeveloperMessage string `json:"developer_message,omitempty"`
 	Code             string `json:"code,omitempty"`
+	// Fields maps request fields to the problems found with them.
+	Fields map[string][]string `json:"fields,omitempty"`
+	// RetryAfter is sent as the Retry-After header when set.
+	RetryAfter time.Duration `json:"-"`
 }
 
 func NewAppError(message, code, developerMessage string) *AppError {
@@ -45,6 +51,36 @@ func BadRequestError(message string) *AppError {
 	return NewAppError(message, "NS-000002", "something wrong with user data")
 }
 
+func ValidationError(fields map[string][]string) *AppError {
+	err := NewAppError("validation failed", "NS-000005", "see fields for the problems with each request field")
+	err.Fields = fields
+	return err
+}
+
+func ConflictError(message string) *AppError {
+	return NewAppError(message, "NS-000007", "resource conflicts with an existing one")
+}
+
+func TooManyRequestsError(message string, retryAfter time.Duration) *AppError {
+	err := NewAppError(message, "NS-000008", "too many requests, retry after the Retry-After header")
+	err.RetryAfter = retryAfter
+	return err
+}
+
+func LockedError(message string, retryAfter time.Duration) *AppError {
+	err := NewAppError(message, "NS-000009", "resource is temporarily locked, retry after the Retry-After header")
+	err.RetryAfter = retryAfter
+	return err
+}
+
+func UnauthorizedError(message string) *AppError {
+	return NewAppError(message, "NS-000003", "missing, invalid or expired credentials")
+}
+
+func ForbiddenError(message string) *AppError {
+	return NewAppError(message, "NS-000004", "authenticated user is not allowed to perform this action")
+}
+
 func systemError(developerMessage string) *AppError {
 	return NewAppError("system error", "NS-000001", developerMessage)
 }
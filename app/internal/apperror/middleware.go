@@ -2,12 +2,26 @@ package apperror
 
 import (
 	"errors"
+	"math"
 	"net/http"
+	"strconv"
 )
 
-type appHandler func(http.ResponseWriter, *http.Request) error
+// AppHandler is an http handler that reports failures by returning an error.
+type AppHandler func(http.ResponseWriter, *http.Request) error
+
+// statusByCode maps application error codes to HTTP status codes.
+// Codes that are not listed here are reported as 400 Bad Request.
+var statusByCode = map[string]int{
+	"NS-000003": http.StatusUnauthorized,
+	"NS-000004": http.StatusForbidden,
+	"NS-000006": http.StatusPreconditionFailed,
+	"NS-000007": http.StatusConflict,
+	"NS-000008": http.StatusTooManyRequests,
+	"NS-000009": http.StatusLocked,
+}
 
-func Middleware(h appHandler) http.HandlerFunc {
+func Middleware(h AppHandler) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		var appErr *AppError
 		err := h(w, r)
@@ -23,9 +37,15 @@ func Middleware(h appHandler) http.HandlerFunc {
 					return
 				}
 
-				err := err.(*AppError)
-				w.WriteHeader(http.StatusBadRequest)
-				w.Write(err.Marshal())
+				status, ok := statusByCode[appErr.Code]
+				if !ok {
+					status = http.StatusBadRequest
+				}
+				if appErr.RetryAfter > 0 {
+					w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(appErr.RetryAfter.Seconds()))))
+				}
+				w.WriteHeader(status)
+				w.Write(appErr.Marshal())
 				return
 			}
 			w.WriteHeader(http.StatusTeapot) //418
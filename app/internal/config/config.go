@@ -1,8 +1,11 @@
 package config
 
 import (
+	"fmt"
 	"os"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/ilyakaznacheev/cleanenv"
 	"github.com/joho/godotenv"
@@ -10,14 +13,134 @@ import (
 )
 
 type Config struct {
-	IsDebug *bool `yaml:"is_debug"`
-	JWT     `yaml:"jwt"`
-	Listen  `yaml:"listen"`
-	MongoDB `yaml:"mongodb" env-required:"true"`
+	IsDebug        *bool `yaml:"is_debug"`
+	JWT            `yaml:"jwt"`
+	Auth           `yaml:"auth"`
+	PasswordPolicy `yaml:"password_policy"`
+	PasswordHash   `yaml:"password_hash"`
+	Lockout        `yaml:"lockout"`
+	MFA            `yaml:"mfa"`
+	SMS            `yaml:"sms"`
+	OTP            `yaml:"otp"`
+	Deletion       `yaml:"deletion"`
+	Listen         `yaml:"listen"`
+	MongoDB        `yaml:"mongodb" env-required:"true"`
 }
 
 type JWT struct {
-	Secret string `yaml:"secret" env-required:"true"`
+	// Algorithm is RS256 or EdDSA. HS256 is the legacy mode signing with the shared Secret.
+	Algorithm string `yaml:"algorithm" env-default:"RS256"`
+	// KeysDir holds the <kid>.pem keyring, see package keyring for the rotation procedure.
+	KeysDir string `yaml:"keys_dir" env-default:"keys"`
+	// Secret signs tokens in HS256 mode. In the other modes it only verifies
+	// HS256 tokens issued before LegacySecretIssuedBefore until they expire.
+	Secret string `yaml:"secret"`
+	// LegacySecretIssuedBefore is when the service switched away from HS256, required while Secret is set
+	// in the other modes. HS256 tokens issued at or after it are rejected, and the secret is no longer
+	// loaded once the last token issued before it has expired.
+	LegacySecretIssuedBefore time.Time `yaml:"legacy_secret_issued_before"`
+
+	AccessTokenTTL  time.Duration `yaml:"access_token_ttl" env-default:"60m"`
+	RefreshTokenTTL time.Duration `yaml:"refresh_token_ttl" env-default:"168h"`
+	// SessionLifetime caps how long a login can be kept alive by rotating refresh tokens.
+	SessionLifetime time.Duration `yaml:"session_lifetime" env-default:"720h"`
+}
+
+type Auth struct {
+	// LegacyGetLogin keeps the deprecated GET /api/users?phone_number=&password= login route registered.
+	LegacyGetLogin bool `yaml:"legacy_get_login" env-default:"false"`
+	// IntrospectionClients are the services allowed to call the token introspection endpoint.
+	IntrospectionClients []Client `yaml:"introspection_clients"`
+}
+
+type Client struct {
+	ID string `yaml:"id"`
+	// Secret is read from the environment variable named by SecretEnv, never from the YAML.
+	Secret string `yaml:"-"`
+}
+
+// SecretEnv returns the environment variable holding the secret of c,
+// e.g. INTROSPECTION_CLIENT_SECRET_LISTING_SERVICE for listing-service.
+func (c Client) SecretEnv() string {
+	return "INTROSPECTION_CLIENT_SECRET_" + strings.ToUpper(strings.ReplaceAll(c.ID, "-", "_"))
+}
+
+type PasswordPolicy struct {
+	// MinLength is counted in characters.
+	MinLength int `yaml:"min_length" env-default:"8"`
+	// MaxLength is counted in bytes and cannot exceed the 72 bytes bcrypt hashes.
+	MaxLength     int  `yaml:"max_length" env-default:"72"`
+	RequireUpper  bool `yaml:"require_upper" env-default:"false"`
+	RequireLower  bool `yaml:"require_lower" env-default:"false"`
+	RequireDigit  bool `yaml:"require_digit" env-default:"false"`
+	RequireSymbol bool `yaml:"require_symbol" env-default:"false"`
+	// BreachedList is a file of rejected passwords, one per line, compared case insensitively.
+	BreachedList string `yaml:"breached_list"`
+}
+
+// PasswordHash selects how new password hashes are made. Hashes of the other algorithm,
+// or made with weaker parameters, are still verified and upgraded when their user logs in.
+type PasswordHash struct {
+	// Algorithm is argon2id or bcrypt.
+	Algorithm  string `yaml:"algorithm" env-default:"argon2id"`
+	BcryptCost int    `yaml:"bcrypt_cost" env-default:"12"`
+	// Argon2Memory is in KiB.
+	Argon2Memory      uint32 `yaml:"argon2_memory" env-default:"19456"`
+	Argon2Iterations  uint32 `yaml:"argon2_iterations" env-default:"2"`
+	Argon2Parallelism uint8  `yaml:"argon2_parallelism" env-default:"1"`
+}
+
+// Lockout slows down and stops password guessing, see the user package for the rules.
+type Lockout struct {
+	// FreeAttempts is how many failed logins of a phone number are allowed without waiting.
+	FreeAttempts int           `yaml:"free_attempts" env-default:"3"`
+	BaseDelay    time.Duration `yaml:"base_delay" env-default:"1s"`
+	MaxDelay     time.Duration `yaml:"max_delay" env-default:"5m"`
+	// LockoutThreshold is how many failed logins lock a phone number for LockoutDuration.
+	LockoutThreshold   int           `yaml:"lockout_threshold" env-default:"10"`
+	IPLockoutThreshold int           `yaml:"ip_lockout_threshold" env-default:"50"`
+	LockoutDuration    time.Duration `yaml:"lockout_duration" env-default:"15m"`
+	// Window is how long failures are remembered after the last one.
+	Window time.Duration `yaml:"window" env-default:"1h"`
+	// TrustedProxies is how many proxies in front of the service append the client IP to X-Forwarded-For.
+	// Zero ignores the header and uses the address of the connection.
+	TrustedProxies int `yaml:"trusted_proxies" env-default:"0"`
+}
+
+type MFA struct {
+	// Issuer is shown next to the account in authenticator apps.
+	Issuer string `yaml:"issuer" env-default:"SDU Housing"`
+	// EnrollmentTTL is how long a new TOTP secret waits for its confirmation code.
+	EnrollmentTTL time.Duration `yaml:"enrollment_ttl" env-default:"10m"`
+	// ChallengeTTL is how long the second login step can be completed after the password was checked.
+	ChallengeTTL  time.Duration `yaml:"challenge_ttl" env-default:"5m"`
+	MaxAttempts   int           `yaml:"max_attempts" env-default:"5"`
+	RecoveryCodes int           `yaml:"recovery_codes" env-default:"10"`
+}
+
+type SMS struct {
+	// Driver is log or file. Both are development stubs, messages are not delivered.
+	Driver string `yaml:"driver" env-default:"log"`
+	// File receives the messages of the file driver.
+	File string `yaml:"file" env-default:"logs/sms.log"`
+}
+
+// OTP configures the one-time codes sent by SMS.
+type OTP struct {
+	TTL time.Duration `yaml:"ttl" env-default:"10m"`
+	// MaxAttempts is how many wrong codes are accepted before the code is discarded.
+	MaxAttempts    int           `yaml:"max_attempts" env-default:"5"`
+	ResendCooldown time.Duration `yaml:"resend_cooldown" env-default:"60s"`
+	// PasswordResetTTL is the shorter lifetime of password reset codes.
+	PasswordResetTTL time.Duration `yaml:"password_reset_ttl" env-default:"5m"`
+}
+
+type Deletion struct {
+	// GracePeriod is how long a deleted account can still be restored by an admin.
+	GracePeriod   time.Duration `yaml:"grace_period" env-default:"720h"`
+	PurgeInterval time.Duration `yaml:"purge_interval" env-default:"1h"`
+	// Anonymize keeps purged documents with their personal data removed instead of deleting them.
+	Anonymize bool `yaml:"anonymize" env-default:"false"`
 }
 
 type Listen struct {
@@ -59,7 +182,65 @@ func GetConfig() *Config {
 
 		// Read Password from environment variable
 		instance.MongoDB.Password = os.Getenv("MONGODB_PASSWORD")
+		for i, client := range instance.Auth.IntrospectionClients {
+			instance.Auth.IntrospectionClients[i].Secret = os.Getenv(client.SecretEnv())
+		}
+
+		if err := instance.validate(); err != nil {
+			logger.Fatal(err)
+		}
 	})
 
 	return instance
 }
+
+// validate rejects values cleanenv accepts but the service cannot run with.
+func (c *Config) validate() error {
+	if err := c.JWT.validate(); err != nil {
+		return err
+	}
+	if err := c.MFA.validate(); err != nil {
+		return err
+	}
+	if err := c.OTP.validate(); err != nil {
+		return err
+	}
+	return c.Deletion.validate()
+}
+
+func (j JWT) validate() error {
+	if j.AccessTokenTTL <= 0 {
+		return fmt.Errorf("jwt.access_token_ttl must be positive, got %s", j.AccessTokenTTL)
+	}
+	if j.RefreshTokenTTL <= 0 {
+		return fmt.Errorf("jwt.refresh_token_ttl must be positive, got %s", j.RefreshTokenTTL)
+	}
+	if j.SessionLifetime <= 0 {
+		return fmt.Errorf("jwt.session_lifetime must be positive, got %s", j.SessionLifetime)
+	}
+	return nil
+}
+
+func (m MFA) validate() error {
+	if m.MaxAttempts <= 0 {
+		return fmt.Errorf("mfa.max_attempts must be positive, got %d", m.MaxAttempts)
+	}
+	return nil
+}
+
+func (o OTP) validate() error {
+	if o.MaxAttempts <= 0 {
+		return fmt.Errorf("otp.max_attempts must be positive, got %d", o.MaxAttempts)
+	}
+	return nil
+}
+
+func (d Deletion) validate() error {
+	if d.GracePeriod <= 0 {
+		return fmt.Errorf("deletion.grace_period must be positive, got %s", d.GracePeriod)
+	}
+	if d.PurgeInterval <= 0 {
+		return fmt.Errorf("deletion.purge_interval must be positive, got %s", d.PurgeInterval)
+	}
+	return nil
+}
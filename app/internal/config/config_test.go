@@ -0,0 +1,45 @@
+package config
+
+import (
+	"testing"
+	"time"
+)
+
+func TestConfigValidate(t *testing.T) {
+	valid := func() Config {
+		var c Config
+		c.JWT.AccessTokenTTL = time.Hour
+		c.JWT.RefreshTokenTTL = 168 * time.Hour
+		c.JWT.SessionLifetime = 720 * time.Hour
+		c.MFA.MaxAttempts = 5
+		c.OTP.MaxAttempts = 5
+		c.Deletion.GracePeriod = 720 * time.Hour
+		c.Deletion.PurgeInterval = time.Hour
+		return c
+	}
+
+	tests := []struct {
+		name    string
+		change  func(c *Config)
+		wantErr bool
+	}{
+		{"valid", func(c *Config) {}, false},
+		{"zero access token ttl", func(c *Config) { c.JWT.AccessTokenTTL = 0 }, true},
+		{"negative refresh token ttl", func(c *Config) { c.JWT.RefreshTokenTTL = -time.Hour }, true},
+		{"zero session lifetime", func(c *Config) { c.JWT.SessionLifetime = 0 }, true},
+		{"zero mfa attempts", func(c *Config) { c.MFA.MaxAttempts = 0 }, true},
+		{"negative otp attempts", func(c *Config) { c.OTP.MaxAttempts = -1 }, true},
+		{"zero grace period", func(c *Config) { c.Deletion.GracePeriod = 0 }, true},
+		{"zero purge interval", func(c *Config) { c.Deletion.PurgeInterval = 0 }, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := valid()
+			tt.change(&c)
+			if err := c.validate(); (err != nil) != tt.wantErr {
+				t.Errorf("validate() error = %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,79 @@
+package password
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/senizdegen/sdu-housing/user-service/internal/config"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// ErrUnsupportedHash is returned when verifying a hash of an unknown format.
+var ErrUnsupportedHash = errors.New("unsupported password hash format")
+
+// Algorithm hashes passwords and verifies the hashes it makes.
+type Algorithm interface {
+	Hash(password string) (string, error)
+	// Verify reports whether password matches hash. Malformed hashes are reported as errors.
+	Verify(hash, password string) (bool, error)
+	// Identifies reports whether hash is in the format of the algorithm.
+	Identifies(hash string) bool
+	// NeedsRehash reports whether hash was made with weaker parameters than the algorithm uses now.
+	NeedsRehash(hash string) bool
+}
+
+// Hasher makes new hashes with the preferred algorithm and verifies hashes of every supported one,
+// so the algorithm can be switched without resetting passwords.
+type Hasher struct {
+	preferred  Algorithm
+	algorithms []Algorithm
+}
+
+// NewHasher returns a Hasher preferring the algorithm selected in cfg.
+func NewHasher(cfg config.PasswordHash) (*Hasher, error) {
+	// bcrypt silently falls back to its default cost below MinCost and fails every hash above MaxCost
+	if cfg.BcryptCost < bcrypt.MinCost || cfg.BcryptCost > bcrypt.MaxCost {
+		return nil, fmt.Errorf("bcrypt cost must be between %d and %d, got %d", bcrypt.MinCost, bcrypt.MaxCost, cfg.BcryptCost)
+	}
+
+	bcrypt := Bcrypt{Cost: cfg.BcryptCost}
+	argon2id := Argon2id{
+		Memory:      cfg.Argon2Memory,
+		Iterations:  cfg.Argon2Iterations,
+		Parallelism: cfg.Argon2Parallelism,
+	}
+	if argon2id.Memory == 0 || argon2id.Iterations == 0 || argon2id.Parallelism == 0 {
+		return nil, errors.New("argon2 memory, iterations and parallelism must be positive")
+	}
+
+	h := &Hasher{algorithms: []Algorithm{bcrypt, argon2id}}
+	switch cfg.Algorithm {
+	case "bcrypt":
+		h.preferred = bcrypt
+	case "argon2id":
+		h.preferred = argon2id
+	default:
+		return nil, fmt.Errorf("unsupported password hash algorithm %q", cfg.Algorithm)
+	}
+
+	return h, nil
+}
+
+func (h *Hasher) Hash(password string) (string, error) {
+	return h.preferred.Hash(password)
+}
+
+// Verify reports whether password matches hash, whatever supported algorithm made it.
+func (h *Hasher) Verify(hash, password string) (bool, error) {
+	for _, algorithm := range h.algorithms {
+		if algorithm.Identifies(hash) {
+			return algorithm.Verify(hash, password)
+		}
+	}
+	return false, ErrUnsupportedHash
+}
+
+// NeedsRehash reports whether hash is not made by the preferred algorithm with its current parameters.
+func (h *Hasher) NeedsRehash(hash string) bool {
+	return !h.preferred.Identifies(hash) || h.preferred.NeedsRehash(hash)
+}
@@ -0,0 +1,171 @@
+package password
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/senizdegen/sdu-housing/user-service/internal/config"
+)
+
+// fastArgon2 keeps the tests quick, the parameters only matter for NeedsRehash.
+var fastArgon2 = Argon2id{Memory: 64, Iterations: 1, Parallelism: 1}
+
+func TestParseArgon2id(t *testing.T) {
+	const salt, key = "c2FsdHNhbHRzYWx0c2FsdA", "a2V5a2V5a2V5a2V5a2V5a2V5a2V5a2V5a2V5a2U"
+
+	tests := []struct {
+		name    string
+		hash    string
+		want    argon2Params
+		wantErr bool
+	}{
+		{
+			name: "valid",
+			hash: "$argon2id$v=19$m=19456,t=2,p=1$" + salt + "$" + key,
+			want: argon2Params{memory: 19456, iterations: 2, parallelism: 1},
+		},
+		{name: "bcrypt", hash: "$2a$12$R9h/cIPz0gi.URNNX3kh2OPST9/PgBkqquzi.Ss7KIUgO2t0jWMUW", wantErr: true},
+		{name: "argon2i", hash: "$argon2i$v=19$m=19456,t=2,p=1$" + salt + "$" + key, wantErr: true},
+		{name: "old version", hash: "$argon2id$v=16$m=19456,t=2,p=1$" + salt + "$" + key, wantErr: true},
+		{name: "missing parameter", hash: "$argon2id$v=19$m=19456,t=2$" + salt + "$" + key, wantErr: true},
+		{name: "zero memory", hash: "$argon2id$v=19$m=0,t=2,p=1$" + salt + "$" + key, wantErr: true},
+		{name: "zero parallelism", hash: "$argon2id$v=19$m=19456,t=2,p=0$" + salt + "$" + key, wantErr: true},
+		{name: "padded salt", hash: "$argon2id$v=19$m=19456,t=2,p=1$" + salt + "==$" + key, wantErr: true},
+		{name: "empty key", hash: "$argon2id$v=19$m=19456,t=2,p=1$" + salt + "$", wantErr: true},
+		{name: "missing key", hash: "$argon2id$v=19$m=19456,t=2,p=1$" + salt, wantErr: true},
+		{name: "leading text", hash: "x$argon2id$v=19$m=19456,t=2,p=1$" + salt + "$" + key, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseArgon2id(tt.hash)
+			if tt.wantErr {
+				if !errors.Is(err, ErrUnsupportedHash) {
+					t.Errorf("error = %v, want ErrUnsupportedHash", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.memory != tt.want.memory || got.iterations != tt.want.iterations || got.parallelism != tt.want.parallelism {
+				t.Errorf("params = m=%d,t=%d,p=%d, want m=%d,t=%d,p=%d",
+					got.memory, got.iterations, got.parallelism, tt.want.memory, tt.want.iterations, tt.want.parallelism)
+			}
+			if len(got.salt) != 16 || len(got.key) != 29 {
+				t.Errorf("salt and key are %d and %d bytes, want 16 and 29", len(got.salt), len(got.key))
+			}
+		})
+	}
+}
+
+func TestArgon2idHashVerify(t *testing.T) {
+	hash, err := fastArgon2.Hash("correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(hash, "$argon2id$v=19$m=64,t=1,p=1$") {
+		t.Errorf("hash %q does not carry its parameters", hash)
+	}
+
+	for password, want := range map[string]bool{"correct horse": true, "wrong horse": false, "": false} {
+		ok, err := fastArgon2.Verify(hash, password)
+		if err != nil || ok != want {
+			t.Errorf("Verify(%q) = %v, %v, want %v", password, ok, err, want)
+		}
+	}
+}
+
+func TestHasherNeedsRehash(t *testing.T) {
+	weakArgon2, err := fastArgon2.Hash("password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	strongArgon2, err := Argon2id{Memory: 128, Iterations: 2, Parallelism: 1}.Hash("password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	weakBcrypt, err := Bcrypt{Cost: 4}.Hash("password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	strongBcrypt, err := Bcrypt{Cost: 5}.Hash("password")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	preferArgon2 := config.PasswordHash{Algorithm: "argon2id", BcryptCost: 5, Argon2Memory: 128, Argon2Iterations: 2, Argon2Parallelism: 1}
+	preferBcrypt := preferArgon2
+	preferBcrypt.Algorithm = "bcrypt"
+
+	tests := []struct {
+		name string
+		cfg  config.PasswordHash
+		hash string
+		want bool
+	}{
+		{"argon2id with current parameters", preferArgon2, strongArgon2, false},
+		{"argon2id with weaker parameters", preferArgon2, weakArgon2, true},
+		{"bcrypt while preferring argon2id", preferArgon2, strongBcrypt, true},
+		{"malformed argon2id", preferArgon2, "$argon2id$v=19$m=128,t=2,p=1$", true},
+		{"bcrypt with current cost", preferBcrypt, strongBcrypt, false},
+		{"bcrypt with lower cost", preferBcrypt, weakBcrypt, true},
+		{"argon2id while preferring bcrypt", preferBcrypt, strongArgon2, true},
+		{"unknown format", preferBcrypt, "plaintext", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hasher, err := NewHasher(tt.cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := hasher.NeedsRehash(tt.hash); got != tt.want {
+				t.Errorf("NeedsRehash = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHasherVerifiesEveryAlgorithm(t *testing.T) {
+	hasher, err := NewHasher(config.PasswordHash{Algorithm: "argon2id", BcryptCost: 4, Argon2Memory: 64, Argon2Iterations: 1, Argon2Parallelism: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	bcryptHash, err := Bcrypt{Cost: 4}.Hash("password")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if ok, err := hasher.Verify(bcryptHash, "password"); err != nil || !ok {
+		t.Errorf("Verify of bcrypt hash = %v, %v, want true", ok, err)
+	}
+	if _, err := hasher.Verify("plaintext", "password"); !errors.Is(err, ErrUnsupportedHash) {
+		t.Errorf("Verify of unknown hash error = %v, want ErrUnsupportedHash", err)
+	}
+}
+
+func TestNewHasherBcryptCost(t *testing.T) {
+	tests := []struct {
+		name    string
+		cost    int
+		wantErr bool
+	}{
+		{"zero", 0, true},
+		{"below minimum", 3, true},
+		{"minimum", 4, false},
+		{"default", 10, false},
+		{"maximum", 31, false},
+		{"above maximum", 32, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewHasher(config.PasswordHash{Algorithm: "bcrypt", BcryptCost: tt.cost, Argon2Memory: 64, Argon2Iterations: 1, Argon2Parallelism: 1})
+			if (err != nil) != tt.wantErr {
+				t.Errorf("NewHasher with cost %d error = %v, want error %v", tt.cost, err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,125 @@
+// Package password checks passwords chosen by users against the configured policy.
+package password
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/senizdegen/sdu-housing/user-service/internal/config"
+)
+
+// MaxBcryptLength is the number of bytes bcrypt hashes, it rejects longer passwords.
+const MaxBcryptLength = 72
+
+// phoneDigits is how many trailing digits of a phone number are looked for in passwords.
+const phoneDigits = 7
+
+type Policy struct {
+	cfg config.PasswordPolicy
+	// breached holds the lowercased passwords of the breached password list.
+	breached map[string]struct{}
+}
+
+// NewPolicy returns the policy configured by cfg, loading its breached password list if any.
+func NewPolicy(cfg config.PasswordPolicy) (*Policy, error) {
+	if cfg.MaxLength <= 0 || cfg.MaxLength > MaxBcryptLength {
+		return nil, fmt.Errorf("password max_length must be 1 to %d bytes", MaxBcryptLength)
+	}
+	if cfg.MinLength > cfg.MaxLength {
+		return nil, fmt.Errorf("password min_length %d is above max_length %d", cfg.MinLength, cfg.MaxLength)
+	}
+
+	p := &Policy{cfg: cfg, breached: map[string]struct{}{}}
+	if cfg.BreachedList == "" {
+		return p, nil
+	}
+
+	file, err := os.Open(cfg.BreachedList)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open breached password list. error: %w", err)
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		if line := strings.TrimSpace(scanner.Text()); line != "" && !strings.HasPrefix(line, "#") {
+			p.breached[strings.ToLower(line)] = struct{}{}
+		}
+	}
+	if err = scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read breached password list. error: %w", err)
+	}
+
+	return p, nil
+}
+
+// Check returns every rule password violates, or nothing if it is acceptable.
+// phoneNumber is the phone number of the user, in E.164 format.
+func (p *Policy) Check(password, phoneNumber string) []string {
+	var violations []string
+
+	if utf8.RuneCountInString(password) < p.cfg.MinLength {
+		violations = append(violations, fmt.Sprintf("must be at least %d characters long", p.cfg.MinLength))
+	}
+	if len(password) > p.cfg.MaxLength {
+		violations = append(violations, fmt.Sprintf("must be at most %d bytes long", p.cfg.MaxLength))
+	}
+
+	var upper, lower, digit, symbol bool
+	for _, r := range password {
+		switch {
+		case unicode.IsUpper(r):
+			upper = true
+		case unicode.IsLower(r):
+			lower = true
+		case unicode.IsDigit(r):
+			digit = true
+		case unicode.IsPunct(r) || unicode.IsSymbol(r) || unicode.IsSpace(r):
+			symbol = true
+		}
+	}
+	if p.cfg.RequireUpper && !upper {
+		violations = append(violations, "must contain an uppercase letter")
+	}
+	if p.cfg.RequireLower && !lower {
+		violations = append(violations, "must contain a lowercase letter")
+	}
+	if p.cfg.RequireDigit && !digit {
+		violations = append(violations, "must contain a digit")
+	}
+	if p.cfg.RequireSymbol && !symbol {
+		violations = append(violations, "must contain a symbol")
+	}
+
+	if containsPhoneNumber(password, phoneNumber) {
+		violations = append(violations, "must not contain your phone number")
+	}
+	if _, ok := p.breached[strings.ToLower(password)]; ok {
+		violations = append(violations, "is known from data breaches, choose another one")
+	}
+
+	return violations
+}
+
+// containsPhoneNumber reports whether password contains the subscriber part of phoneNumber,
+// ignoring the separators people write phone numbers with.
+func containsPhoneNumber(password, phoneNumber string) bool {
+	digits := strings.TrimPrefix(phoneNumber, "+")
+	if len(digits) < phoneDigits {
+		return false
+	}
+
+	stripped := strings.Map(func(r rune) rune {
+		switch r {
+		case ' ', '-', '.', '(', ')', '+':
+			return -1
+		}
+		return r
+	}, password)
+
+	return strings.Contains(stripped, digits[len(digits)-phoneDigits:])
+}
@@ -0,0 +1,98 @@
+package password
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/senizdegen/sdu-housing/user-service/internal/config"
+)
+
+func TestPolicyCheck(t *testing.T) {
+	breached := filepath.Join(t.TempDir(), "breached.txt")
+	if err := os.WriteFile(breached, []byte("# comment\nQwerty123!\n\n  letmein  \n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	strict := config.PasswordPolicy{
+		MinLength:     8,
+		MaxLength:     MaxBcryptLength,
+		RequireUpper:  true,
+		RequireLower:  true,
+		RequireDigit:  true,
+		RequireSymbol: true,
+		BreachedList:  breached,
+	}
+	lenient := config.PasswordPolicy{MinLength: 8, MaxLength: 16, BreachedList: breached}
+
+	const phoneNumber = "+77011234567"
+
+	tests := []struct {
+		name     string
+		cfg      config.PasswordPolicy
+		password string
+		want     []string
+	}{
+		{"acceptable", strict, "Correct-Horse1", nil},
+		{"too short", lenient, "abc", []string{"must be at least 8 characters long"}},
+		{"length counted in characters", lenient, "пароль12", nil},
+		{"too long in bytes", lenient, "пароль-пароль-12", []string{"must be at most 16 bytes long"}},
+		{"missing classes", strict, "abcdefgh", []string{
+			"must contain an uppercase letter",
+			"must contain a digit",
+			"must contain a symbol",
+		}},
+		{"missing lowercase", strict, "ABCDEFG1!", []string{"must contain a lowercase letter"}},
+		{"space is a symbol", strict, "Correct horse1", nil},
+		{"subscriber number", lenient, "my1234567pw", []string{"must not contain your phone number"}},
+		{"subscriber number with separators", lenient, "x123-45-67x", []string{"must not contain your phone number"}},
+		{"shorter part of the number", lenient, "x234567xyz", nil},
+		{"breached ignoring case", lenient, "QWERTY123!", []string{"is known from data breaches, choose another one"}},
+		{"breached trimmed", lenient, "letmein", []string{
+			"must be at least 8 characters long",
+			"is known from data breaches, choose another one",
+		}},
+		{"comment is not breached", lenient, "# comment", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			policy, err := NewPolicy(tt.cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := policy.Check(tt.password, phoneNumber); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Check(%q) = %q, want %q", tt.password, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPolicyCheckWithoutPhoneNumber(t *testing.T) {
+	policy, err := NewPolicy(config.PasswordPolicy{MinLength: 1, MaxLength: MaxBcryptLength})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := policy.Check("1234567", ""); got != nil {
+		t.Errorf("Check without phone number = %q, want nothing", got)
+	}
+}
+
+func TestNewPolicyInvalid(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  config.PasswordPolicy
+	}{
+		{"max length above bcrypt", config.PasswordPolicy{MinLength: 8, MaxLength: MaxBcryptLength + 1}},
+		{"no max length", config.PasswordPolicy{MinLength: 8}},
+		{"min above max", config.PasswordPolicy{MinLength: 20, MaxLength: 16}},
+		{"missing breached list", config.PasswordPolicy{MinLength: 8, MaxLength: 16, BreachedList: filepath.Join(t.TempDir(), "missing.txt")}},
+	}
+
+	for _, tt := range tests {
+		if _, err := NewPolicy(tt.cfg); err == nil {
+			t.Errorf("%s: NewPolicy succeeded", tt.name)
+		}
+	}
+}
@@ -0,0 +1,354 @@
+package user
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+
+	"github.com/senizdegen/sdu-housing/user-service/internal/apperror"
+)
+
+func (h *Handler) Login(w http.ResponseWriter, r *http.Request) error {
+	h.Logger.Info("LOGIN")
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+
+	h.Logger.Debug("decode login dto")
+	var dto LoginDTO
+	defer r.Body.Close()
+	if err := json.NewDecoder(r.Body).Decode(&dto); err != nil {
+		return apperror.BadRequestError("invalid JSON scheme. check swagger API")
+	}
+	if dto.PhoneNumber == "" || dto.Password == "" {
+		return apperror.BadRequestError("phone_number and password are required")
+	}
+	dto.ClientIP = h.clientIP(r)
+
+	resp, err := h.UserService.Login(r.Context(), dto)
+	if err != nil {
+		return err
+	}
+
+	h.Logger.Debug("marshal login response")
+	respBytes, err := json.Marshal(resp)
+	if err != nil {
+		return fmt.Errorf("failed to marshall login response. error: %w", err)
+	}
+
+	w.WriteHeader(http.StatusOK)
+	w.Write(respBytes)
+
+	return nil
+}
+
+// LoginMFA completes the login of a user with two-factor authentication,
+// exchanging the mfa_token returned by Login and a TOTP or recovery code for the token pair.
+func (h *Handler) LoginMFA(w http.ResponseWriter, r *http.Request) error {
+	h.Logger.Info("LOGIN MFA")
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+
+	h.Logger.Debug("decode mfa login dto")
+	var dto MFALoginDTO
+	defer r.Body.Close()
+	if err := json.NewDecoder(r.Body).Decode(&dto); err != nil {
+		return apperror.BadRequestError("invalid JSON scheme. check swagger API")
+	}
+	if dto.MFAToken == "" || (dto.Code == "") == (dto.RecoveryCode == "") {
+		return apperror.BadRequestError("mfa_token and either code or recovery_code are required")
+	}
+	dto.ClientIP = h.clientIP(r)
+
+	resp, err := h.UserService.LoginMFA(r.Context(), dto)
+	if err != nil {
+		return err
+	}
+
+	respBytes, err := json.Marshal(resp)
+	if err != nil {
+		return fmt.Errorf("failed to marshall login response. error: %w", err)
+	}
+
+	w.WriteHeader(http.StatusOK)
+	w.Write(respBytes)
+
+	return nil
+}
+
+// VerifyPhone confirms the phone number with the code sent on registration and logs the user in.
+func (h *Handler) VerifyPhone(w http.ResponseWriter, r *http.Request) error {
+	h.Logger.Info("VERIFY PHONE")
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+
+	h.Logger.Debug("decode verify phone dto")
+	var dto VerifyPhoneDTO
+	defer r.Body.Close()
+	if err := json.NewDecoder(r.Body).Decode(&dto); err != nil {
+		return apperror.BadRequestError("invalid JSON scheme. check swagger API")
+	}
+	if dto.PhoneNumber == "" || dto.Code == "" {
+		return apperror.BadRequestError("phone_number and code are required")
+	}
+
+	resp, err := h.UserService.VerifyPhone(r.Context(), dto)
+	if err != nil {
+		return err
+	}
+
+	respBytes, err := json.Marshal(resp)
+	if err != nil {
+		return fmt.Errorf("failed to marshall login response. error: %w", err)
+	}
+
+	w.WriteHeader(http.StatusOK)
+	w.Write(respBytes)
+
+	return nil
+}
+
+func (h *Handler) ResendVerificationCode(w http.ResponseWriter, r *http.Request) error {
+	h.Logger.Info("RESEND VERIFICATION CODE")
+
+	h.Logger.Debug("decode send code dto")
+	var dto SendCodeDTO
+	defer r.Body.Close()
+	if err := json.NewDecoder(r.Body).Decode(&dto); err != nil {
+		return apperror.BadRequestError("invalid JSON scheme. check swagger API")
+	}
+	if dto.PhoneNumber == "" {
+		return apperror.BadRequestError("phone_number is required")
+	}
+
+	if err := h.UserService.ResendVerificationCode(r.Context(), dto); err != nil {
+		return err
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+
+	return nil
+}
+
+// ForgotPassword sends a password reset code by SMS. It answers 202 whether or not the number is registered.
+func (h *Handler) ForgotPassword(w http.ResponseWriter, r *http.Request) error {
+	h.Logger.Info("FORGOT PASSWORD")
+
+	h.Logger.Debug("decode send code dto")
+	var dto SendCodeDTO
+	defer r.Body.Close()
+	if err := json.NewDecoder(r.Body).Decode(&dto); err != nil {
+		return apperror.BadRequestError("invalid JSON scheme. check swagger API")
+	}
+	if dto.PhoneNumber == "" {
+		return apperror.BadRequestError("phone_number is required")
+	}
+
+	if err := h.UserService.ForgotPassword(r.Context(), dto); err != nil {
+		return err
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+
+	return nil
+}
+
+// ResetPassword sets a new password with the code sent by ForgotPassword and logs the user out everywhere.
+func (h *Handler) ResetPassword(w http.ResponseWriter, r *http.Request) error {
+	h.Logger.Info("RESET PASSWORD")
+
+	h.Logger.Debug("decode reset password dto")
+	var dto ResetPasswordDTO
+	defer r.Body.Close()
+	if err := json.NewDecoder(r.Body).Decode(&dto); err != nil {
+		return apperror.BadRequestError("invalid JSON scheme. check swagger API")
+	}
+	if dto.PhoneNumber == "" || dto.Code == "" {
+		return apperror.BadRequestError("phone_number and code are required")
+	}
+
+	if err := h.UserService.ResetPassword(r.Context(), dto); err != nil {
+		return err
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+
+	return nil
+}
+
+func (h *Handler) RefreshToken(w http.ResponseWriter, r *http.Request) error {
+	h.Logger.Info("REFRESH TOKEN")
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+
+	h.Logger.Debug("decode refresh token")
+	var rt RT
+	defer r.Body.Close()
+	if err := json.NewDecoder(r.Body).Decode(&rt); err != nil {
+		return apperror.BadRequestError("invalid JSON scheme. check swagger API")
+	}
+	if rt.RefreshToken == "" {
+		return apperror.BadRequestError("refresh_token is required")
+	}
+
+	tokenBytes, err := h.UserService.UpdateRefreshToken(r.Context(), rt)
+	if err != nil {
+		return err
+	}
+
+	w.WriteHeader(http.StatusOK)
+	w.Write(tokenBytes)
+
+	return nil
+}
+
+func (h *Handler) Logout(w http.ResponseWriter, r *http.Request) error {
+	h.Logger.Info("LOGOUT")
+
+	claims, _ := ClaimsFromContext(r.Context())
+
+	h.Logger.Debug("decode refresh token")
+	var rt RT
+	defer r.Body.Close()
+	if err := json.NewDecoder(r.Body).Decode(&rt); err != nil && !errors.Is(err, io.EOF) {
+		return apperror.BadRequestError("invalid JSON scheme. check swagger API")
+	}
+
+	if err := h.UserService.Logout(claims, rt); err != nil {
+		return err
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+
+	return nil
+}
+
+func (h *Handler) LogoutAll(w http.ResponseWriter, r *http.Request) error {
+	h.Logger.Info("LOGOUT ALL")
+
+	claims, _ := ClaimsFromContext(r.Context())
+	if err := h.UserService.LogoutAll(claims); err != nil {
+		return err
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+
+	return nil
+}
+
+// ClearLockout lets an admin unlock logins of the phone_number and/or ip query parameters.
+func (h *Handler) ClearLockout(w http.ResponseWriter, r *http.Request) error {
+	h.Logger.Info("CLEAR LOCKOUT")
+
+	query := r.URL.Query()
+	if err := h.UserService.ClearLockout(r.Context(), query.Get("phone_number"), query.Get("ip")); err != nil {
+		return err
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+
+	return nil
+}
+
+// Introspect reports whether an access token is active, see RFC 7662.
+// Callers authenticate with HTTP Basic client credentials from IntrospectionClients.
+func (h *Handler) Introspect(w http.ResponseWriter, r *http.Request) error {
+	h.Logger.Info("INTROSPECT TOKEN")
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+
+	clientID, ok := h.authenticateClient(r)
+	if !ok {
+		w.Header().Set("WWW-Authenticate", `Basic realm="introspect"`)
+		return apperror.UnauthorizedError("invalid client credentials")
+	}
+
+	token, err := introspectionToken(r)
+	if err != nil {
+		return err
+	}
+	h.Logger.Debugf("token introspected by client %s", clientID)
+
+	resp := IntrospectionResponse{}
+	claims, err := h.UserService.VerifyAccessToken(token)
+	if err == nil {
+		resp = IntrospectionResponse{
+			Active:    true,
+			TokenType: "access_token",
+			Subject:   claims.Subject,
+			Audience:  claims.Audience,
+			ID:        claims.ID,
+			UUID:      claims.UUID,
+			Role:      claims.Role,
+		}
+		if claims.ExpiresAt != nil {
+			resp.ExpiresAt = claims.ExpiresAt.Unix()
+		}
+		if claims.IssuedAt != nil {
+			resp.IssuedAt = claims.IssuedAt.Unix()
+		}
+	} else {
+		var appErr *apperror.AppError
+		if !errors.As(err, &appErr) {
+			return err
+		}
+		h.Logger.Debugf("introspected token is inactive. error: %s", err)
+	}
+
+	respBytes, err := json.Marshal(resp)
+	if err != nil {
+		return fmt.Errorf("failed to marshall introspection response. error: %w", err)
+	}
+
+	w.WriteHeader(http.StatusOK)
+	w.Write(respBytes)
+
+	return nil
+}
+
+// authenticateClient checks the HTTP Basic credentials of r and returns the client id.
+func (h *Handler) authenticateClient(r *http.Request) (string, bool) {
+	clientID, secret, ok := r.BasicAuth()
+	if !ok {
+		return "", false
+	}
+
+	expected, ok := h.IntrospectionClients[clientID]
+	if !ok || expected == "" {
+		return "", false
+	}
+
+	return clientID, subtle.ConstantTimeCompare([]byte(secret), []byte(expected)) == 1
+}
+
+// introspectionToken reads the token parameter from a form or JSON body.
+func introspectionToken(r *http.Request) (string, error) {
+	defer r.Body.Close()
+
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType == "application/json" {
+		var body struct {
+			Token string `json:"token"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			return "", apperror.BadRequestError("invalid JSON scheme. check swagger API")
+		}
+		if body.Token == "" {
+			return "", apperror.BadRequestError("token is required")
+		}
+		return body.Token, nil
+	}
+
+	if err := r.ParseForm(); err != nil {
+		return "", apperror.BadRequestError("invalid form body")
+	}
+	token := r.PostForm.Get("token")
+	if token == "" {
+		return "", apperror.BadRequestError("token is required")
+	}
+
+	return token, nil
+}
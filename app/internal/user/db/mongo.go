@@ -4,37 +4,187 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"regexp"
 	"time"
 
 	"github.com/senizdegen/sdu-housing/user-service/internal/apperror"
 	"github.com/senizdegen/sdu-housing/user-service/internal/user"
 	"github.com/senizdegen/sdu-housing/user-service/pkg/logging"
+	"github.com/senizdegen/sdu-housing/user-service/pkg/phone"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 var _ user.Storage = &db{}
 
+// notDeleted matches users that are not soft deleted.
+var notDeleted = bson.M{"$exists": false}
+
 type db struct {
 	collection *mongo.Collection
 	logger     logging.Logger
 }
 
-func NewStorage(storage *mongo.Database, collection string, logger logging.Logger) user.Storage {
-	return &db{
+func NewStorage(ctx context.Context, storage *mongo.Database, collection string, logger logging.Logger) (user.Storage, error) {
+	s := &db{
 		collection: storage.Collection(collection),
 		logger:     logger,
 	}
+
+	// must run before phone_number_unique is created, numbers stored in different formats would not collide
+	if err := s.normalizePhoneNumbers(ctx); err != nil {
+		return nil, err
+	}
+	if err := s.storeNameWords(ctx); err != nil {
+		return nil, err
+	}
+	if err := s.createIndexes(ctx); err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+func (s *db) createIndexes(ctx context.Context) error {
+	indexes := []mongo.IndexModel{
+		{
+			// answers name word prefix searches
+			Keys:    bson.D{{Key: "name_words", Value: 1}},
+			Options: options.Index().SetName("name_words"),
+		},
+		{
+			// also answers phone number prefix searches, anonymized users have no phone number
+			Keys: bson.D{{Key: "phone_number", Value: 1}},
+			Options: options.Index().
+				SetName("phone_number_unique").
+				SetUnique(true).
+				SetPartialFilterExpression(bson.M{"phone_number": bson.M{"$type": "string"}}),
+		},
+	}
+
+	nCtx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+
+	if _, err := s.collection.Indexes().CreateMany(nCtx, indexes); err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return fmt.Errorf("failed to create indexes, remove duplicate phone numbers first. error: %w", err)
+		}
+		return fmt.Errorf("failed to create indexes. error: %w", err)
+	}
+
+	return nil
+}
+
+// normalizePhoneNumbers rewrites phone numbers stored before they were normalized into E.164,
+// so their users can log in again. Invalid numbers and numbers another user already has in E.164
+// are logged and left as they are.
+func (s *db) normalizePhoneNumbers(ctx context.Context) error {
+	nCtx, cancel := context.WithTimeout(ctx, 5*time.Minute)
+	defer cancel()
+
+	filter := bson.M{"phone_number": bson.M{"$type": "string", "$not": primitive.Regex{Pattern: `^\+[1-9][0-9]+$`}}}
+	cursor, err := s.collection.Find(nCtx, filter, options.Find().SetProjection(bson.M{"phone_number": 1}))
+	if err != nil {
+		return fmt.Errorf("failed to find phone numbers to normalize. error: %w", err)
+	}
+	defer cursor.Close(nCtx)
+
+	var normalized, skipped int
+	for cursor.Next(nCtx) {
+		var doc struct {
+			ID          primitive.ObjectID `bson:"_id"`
+			PhoneNumber string             `bson:"phone_number"`
+		}
+		if err = cursor.Decode(&doc); err != nil {
+			return fmt.Errorf("failed to decode document. error: %w", err)
+		}
+
+		phoneNumber, err := phone.Normalize(doc.PhoneNumber, phone.DefaultRegion)
+		if err != nil {
+			s.logger.Warnf("user %s has invalid phone number %q, leaving it as it is", doc.ID.Hex(), doc.PhoneNumber)
+			skipped++
+			continue
+		}
+
+		taken, err := s.collection.CountDocuments(nCtx, bson.M{"phone_number": phoneNumber, "_id": bson.M{"$ne": doc.ID}})
+		if err != nil {
+			return fmt.Errorf("failed to execute query. error: %w", err)
+		}
+		if taken > 0 {
+			s.logger.Warnf("phone number %q of user %s is also stored as %s, leaving it as it is", doc.PhoneNumber, doc.ID.Hex(), phoneNumber)
+			skipped++
+			continue
+		}
+
+		update := bson.M{"$set": bson.M{"phone_number": phoneNumber}, "$inc": bson.M{"version": 1}}
+		if _, err = s.collection.UpdateOne(nCtx, bson.M{"_id": doc.ID, "phone_number": doc.PhoneNumber}, update); err != nil {
+			if mongo.IsDuplicateKeyError(err) {
+				s.logger.Warnf("phone number %q of user %s is also stored as %s, leaving it as it is", doc.PhoneNumber, doc.ID.Hex(), phoneNumber)
+				skipped++
+				continue
+			}
+			return fmt.Errorf("failed to normalize phone number. error: %w", err)
+		}
+		normalized++
+	}
+	if err = cursor.Err(); err != nil {
+		return fmt.Errorf("failed to iterate phone numbers to normalize. error: %w", err)
+	}
+
+	if normalized > 0 || skipped > 0 {
+		s.logger.Infof("normalized %d stored phone numbers, skipped %d", normalized, skipped)
+	}
+	return nil
+}
+
+// storeNameWords sets the name words of users stored before names were searched by word prefixes.
+func (s *db) storeNameWords(ctx context.Context) error {
+	nCtx, cancel := context.WithTimeout(ctx, 5*time.Minute)
+	defer cancel()
+
+	filter := bson.M{"full_name": bson.M{"$type": "string"}, "name_words": bson.M{"$exists": false}}
+	cursor, err := s.collection.Find(nCtx, filter, options.Find().SetProjection(bson.M{"full_name": 1}))
+	if err != nil {
+		return fmt.Errorf("failed to find names without words. error: %w", err)
+	}
+	defer cursor.Close(nCtx)
+
+	var stored int
+	for cursor.Next(nCtx) {
+		var doc struct {
+			ID       primitive.ObjectID `bson:"_id"`
+			FullName string             `bson:"full_name"`
+		}
+		if err = cursor.Decode(&doc); err != nil {
+			return fmt.Errorf("failed to decode document. error: %w", err)
+		}
+
+		update := bson.M{"$set": bson.M{"name_words": user.NameWords(doc.FullName)}}
+		if _, err = s.collection.UpdateOne(nCtx, bson.M{"_id": doc.ID, "full_name": doc.FullName}, update); err != nil {
+			return fmt.Errorf("failed to store name words. error: %w", err)
+		}
+		stored++
+	}
+	if err = cursor.Err(); err != nil {
+		return fmt.Errorf("failed to iterate names without words. error: %w", err)
+	}
+
+	if stored > 0 {
+		s.logger.Infof("stored the name words of %d users", stored)
+	}
+	return nil
 }
 
 func (s *db) FindOne(ctx context.Context, uuid string) (u user.User, err error) {
 	objectID, err := primitive.ObjectIDFromHex(uuid)
 	if err != nil {
-		return u, fmt.Errorf("failed to convert hex to objectid. error: %w", err)
+		s.logger.Debugf("failed to convert hex to objectid. error: %s", err)
+		return u, apperror.ErrNotFound
 	}
 
-	filter := bson.M{"_id": objectID}
+	filter := bson.M{"_id": objectID, "deleted_at": notDeleted}
 
 	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
 	defer cancel()
@@ -45,7 +195,7 @@ func (s *db) FindOne(ctx context.Context, uuid string) (u user.User, err error)
 		if errors.Is(result.Err(), mongo.ErrNoDocuments) {
 			return u, apperror.ErrNotFound
 		}
-		return u, fmt.Errorf("failed to execute query. error: %w", err)
+		return u, fmt.Errorf("failed to execute query. error: %w", result.Err())
 	}
 	if err = result.Decode(&u); err != nil {
 		return u, fmt.Errorf("failed to decode document. error: %w", err)
@@ -56,20 +206,18 @@ func (s *db) FindOne(ctx context.Context, uuid string) (u user.User, err error)
 
 func (s *db) FindByPhoneNumber(ctx context.Context, phoneNumber string) (u user.User, err error) {
 	s.logger.Debug("FIND BY PHONE NUMBER")
-	filter := bson.M{"phone_number": phoneNumber}
+	filter := bson.M{"phone_number": phoneNumber, "deleted_at": notDeleted}
 
 	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
 	defer cancel()
 
 	result := s.collection.FindOne(ctx, filter)
-	s.logger.Trace(result)
-
-	if err != nil {
+	if result.Err() != nil {
 		if errors.Is(result.Err(), mongo.ErrNoDocuments) {
-			s.logger.Error("error not found")
+			s.logger.Debug("user not found by phone number")
 			return u, apperror.ErrNotFound
 		}
-		return u, fmt.Errorf("failed to execute query. error: %w", err)
+		return u, fmt.Errorf("failed to execute query. error: %w", result.Err())
 	}
 	if err = result.Decode(&u); err != nil {
 		return u, fmt.Errorf("failed to decode document. error: %w", err)
@@ -78,12 +226,167 @@ func (s *db) FindByPhoneNumber(ctx context.Context, phoneNumber string) (u user.
 	return u, nil
 }
 
-func (s *db) Create(ctx context.Context, user user.User) (string, error) {
+func (s *db) Find(ctx context.Context, filter user.UserFilter) ([]user.User, error) {
+	query := bson.M{}
+
+	if len(filter.Roles) > 0 {
+		roles := bson.A{}
+		for _, role := range filter.Roles {
+			roles = append(roles, role)
+			if role == user.DefaultRole {
+				// users stored before roles existed have none
+				roles = append(roles, nil)
+			}
+		}
+		query["role"] = bson.M{"$in": roles}
+	}
+
+	createdAt := bson.M{}
+	if filter.CreatedFrom != 0 {
+		createdAt["$gte"] = filter.CreatedFrom
+	}
+	if filter.CreatedTo != 0 {
+		createdAt["$lte"] = filter.CreatedTo
+	}
+	if len(createdAt) > 0 {
+		query["created_at"] = createdAt
+	}
+
+	switch filter.Deleted {
+	case user.DeletedOnly:
+		query["deleted_at"] = bson.M{"$exists": true}
+	case user.DeletedInclude:
+	default:
+		query["deleted_at"] = notDeleted
+	}
+
+	sortOrder := -1
+	cursorOp := "$lt"
+	if filter.Sort == user.SortCreatedAtAsc {
+		sortOrder = 1
+		cursorOp = "$gt"
+	}
+	if filter.Cursor != "" {
+		cursorID, err := primitive.ObjectIDFromHex(filter.Cursor)
+		if err != nil {
+			return nil, apperror.BadRequestError("cursor is invalid")
+		}
+		query["_id"] = bson.M{cursorOp: cursorID}
+	}
+
+	opts := options.Find().
+		SetSort(bson.D{{Key: "_id", Value: sortOrder}}).
+		SetLimit(int64(filter.Limit))
+
+	nCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	cursor, err := s.collection.Find(nCtx, query, opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute query. error: %w", err)
+	}
+
+	users := make([]user.User, 0, filter.Limit)
+	if err = cursor.All(nCtx, &users); err != nil {
+		return nil, fmt.Errorf("failed to decode documents. error: %w", err)
+	}
+
+	return users, nil
+}
+
+func (s *db) Search(ctx context.Context, query user.SearchQuery) ([]user.SearchHit, error) {
+	var pipeline mongo.Pipeline
+	if query.Field == user.SearchFieldPhoneNumber {
+		// Stored numbers are E.164, so the regex has a literal prefix, and the $type condition
+		// of the partial phone_number_unique index lets it answer the query and the sort.
+		pipeline = mongo.Pipeline{
+			{{Key: "$match", Value: bson.M{
+				"deleted_at":   notDeleted,
+				"phone_number": bson.M{"$type": "string", "$regex": primitive.Regex{Pattern: "^\\+" + regexp.QuoteMeta(query.Text)}},
+			}}},
+			{{Key: "$sort", Value: bson.D{{Key: "phone_number", Value: 1}}}},
+			{{Key: "$addFields", Value: bson.M{"score": 1}}},
+		}
+	} else {
+		// every term must start one of the lowercased name words, anchored regexes are answered from the name_words index
+		terms := user.NameWords(query.Text)
+		prefixes := bson.A{}
+		termScores := bson.A{}
+		for _, term := range terms {
+			prefixes = append(prefixes, primitive.Regex{Pattern: "^" + regexp.QuoteMeta(term)})
+			termScores = append(termScores, nameTermScore(term))
+		}
+		pipeline = mongo.Pipeline{
+			{{Key: "$match", Value: bson.M{"deleted_at": notDeleted, "name_words": bson.M{"$all": prefixes}}}},
+			{{Key: "$addFields", Value: bson.M{"score": bson.M{"$avg": termScores}}}},
+			{{Key: "$sort", Value: bson.D{{Key: "score", Value: -1}, {Key: "full_name", Value: 1}, {Key: "_id", Value: 1}}}},
+		}
+	}
+	pipeline = append(pipeline,
+		bson.D{{Key: "$skip", Value: int64(query.Offset)}},
+		bson.D{{Key: "$limit", Value: int64(query.Limit)}},
+	)
+
+	nCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	cursor, err := s.collection.Aggregate(nCtx, pipeline)
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute query. error: %w", err)
+	}
+	defer cursor.Close(nCtx)
+
+	hits := make([]user.SearchHit, 0, query.Limit)
+	for cursor.Next(nCtx) {
+		var doc struct {
+			user.User `bson:",inline"`
+			Score     float64 `bson:"score"`
+		}
+		if err = cursor.Decode(&doc); err != nil {
+			return nil, fmt.Errorf("failed to decode document. error: %w", err)
+		}
+		hits = append(hits, user.SearchHit{User: doc.User, Score: doc.Score})
+	}
+	if err = cursor.Err(); err != nil {
+		return nil, fmt.Errorf("failed to iterate documents. error: %w", err)
+	}
+
+	return hits, nil
+}
+
+// nameTermScore scores the best name word term matches, see user.SearchHit:
+// 2 for the whole word, 1 for a prefix, plus 1/(k+2) for the k-th word.
+func nameTermScore(term string) bson.M {
+	position := bson.M{"$divide": bson.A{1, bson.M{"$add": bson.A{"$$k", 2}}}}
+	return bson.M{"$max": bson.M{"$map": bson.M{
+		"input": bson.M{"$range": bson.A{0, bson.M{"$size": "$name_words"}}},
+		"as":    "k",
+		"in": bson.M{"$let": bson.M{
+			"vars": bson.M{"word": bson.M{"$arrayElemAt": bson.A{"$name_words", "$$k"}}},
+			"in": bson.M{"$switch": bson.M{
+				"branches": bson.A{
+					bson.M{"case": bson.M{"$eq": bson.A{"$$word", term}}, "then": bson.M{"$add": bson.A{2, position}}},
+					bson.M{
+						"case": bson.M{"$regexMatch": bson.M{"input": "$$word", "regex": "^" + regexp.QuoteMeta(term)}},
+						"then": bson.M{"$add": bson.A{1, position}},
+					},
+				},
+				"default": 0,
+			}},
+		}},
+	}}}
+}
+
+func (s *db) Create(ctx context.Context, u user.User) (string, error) {
 	nCtx, cancel := context.WithTimeout(ctx, time.Second*5)
 	defer cancel()
 
-	result, err := s.collection.InsertOne(nCtx, user)
+	u.NameWords = user.NameWords(u.FullName)
+	result, err := s.collection.InsertOne(nCtx, u)
 	if err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return "", user.ErrPhoneNumberTaken
+		}
 		return "", fmt.Errorf("failed to execute query. error: %s", err)
 	}
 
@@ -93,3 +396,127 @@ func (s *db) Create(ctx context.Context, user user.User) (string, error) {
 	}
 	return "", fmt.Errorf("failed to convert object id to hex")
 }
+
+func (s *db) Update(ctx context.Context, u user.User) error {
+	objectID, err := primitive.ObjectIDFromHex(u.UUID)
+	if err != nil {
+		return apperror.ErrNotFound
+	}
+	// _id is immutable, the filter selects the document instead
+	u.UUID = ""
+
+	filter := bson.M{"_id": objectID, "deleted_at": notDeleted, "version": u.Version}
+	if u.Version == 0 {
+		filter["version"] = bson.M{"$exists": false}
+	}
+	u.Version++
+	u.NameWords = user.NameWords(u.FullName)
+
+	nCtx, cancel := context.WithTimeout(ctx, time.Second*5)
+	defer cancel()
+
+	result, err := s.collection.ReplaceOne(nCtx, filter, u)
+	if err != nil {
+		return fmt.Errorf("failed to execute query. error: %w", err)
+	}
+	if result.MatchedCount == 0 {
+		count, err := s.collection.CountDocuments(nCtx, bson.M{"_id": objectID, "deleted_at": notDeleted})
+		if err != nil {
+			return fmt.Errorf("failed to execute query. error: %w", err)
+		}
+		if count == 0 {
+			return apperror.ErrNotFound
+		}
+		return apperror.ErrPreconditionFailed
+	}
+
+	return nil
+}
+
+func (s *db) Delete(ctx context.Context, uuid string, at int64) error {
+	objectID, err := primitive.ObjectIDFromHex(uuid)
+	if err != nil {
+		return apperror.ErrNotFound
+	}
+
+	filter := bson.M{"_id": objectID, "deleted_at": notDeleted}
+	update := bson.M{
+		"$set": bson.M{"deleted_at": at, "updated_at": at},
+		"$inc": bson.M{"version": 1},
+	}
+
+	nCtx, cancel := context.WithTimeout(ctx, time.Second*5)
+	defer cancel()
+
+	result, err := s.collection.UpdateOne(nCtx, filter, update)
+	if err != nil {
+		return fmt.Errorf("failed to execute query. error: %w", err)
+	}
+	if result.MatchedCount == 0 {
+		return apperror.ErrNotFound
+	}
+
+	return nil
+}
+
+func (s *db) Restore(ctx context.Context, uuid string, deletedAfter int64) error {
+	objectID, err := primitive.ObjectIDFromHex(uuid)
+	if err != nil {
+		return apperror.ErrNotFound
+	}
+
+	filter := bson.M{
+		"_id":        objectID,
+		"deleted_at": bson.M{"$gt": deletedAfter},
+		"purged_at":  bson.M{"$exists": false},
+	}
+	update := bson.M{
+		"$unset": bson.M{"deleted_at": ""},
+		"$set":   bson.M{"updated_at": time.Now().Unix()},
+		"$inc":   bson.M{"version": 1},
+	}
+
+	nCtx, cancel := context.WithTimeout(ctx, time.Second*5)
+	defer cancel()
+
+	result, err := s.collection.UpdateOne(nCtx, filter, update)
+	if err != nil {
+		return fmt.Errorf("failed to execute query. error: %w", err)
+	}
+	if result.MatchedCount == 0 {
+		return apperror.ErrNotFound
+	}
+
+	return nil
+}
+
+func (s *db) Purge(ctx context.Context, deletedBefore int64, anonymize bool) (int64, error) {
+	filter := bson.M{
+		"deleted_at": bson.M{"$lte": deletedBefore},
+		"purged_at":  bson.M{"$exists": false},
+	}
+
+	nCtx, cancel := context.WithTimeout(ctx, time.Second*30)
+	defer cancel()
+
+	if !anonymize {
+		result, err := s.collection.DeleteMany(nCtx, filter)
+		if err != nil {
+			return 0, fmt.Errorf("failed to execute query. error: %w", err)
+		}
+		return result.DeletedCount, nil
+	}
+
+	now := time.Now().Unix()
+	update := bson.M{
+		"$unset": bson.M{"phone_number": "", "password": "", "avatar_url": "", "totp_secret": "", "recovery_codes": "", "name_words": ""},
+		"$set":   bson.M{"full_name": "Deleted user", "purged_at": now, "updated_at": now},
+		"$inc":   bson.M{"version": 1},
+	}
+	result, err := s.collection.UpdateMany(nCtx, filter, update)
+	if err != nil {
+		return 0, fmt.Errorf("failed to execute query. error: %w", err)
+	}
+
+	return result.ModifiedCount, nil
+}
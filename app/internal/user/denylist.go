@@ -0,0 +1,134 @@
+package user
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/senizdegen/sdu-housing/user-service/internal/apperror"
+	"github.com/senizdegen/sdu-housing/user-service/internal/config"
+)
+
+// The denylist lives in the same cache as refresh tokens.
+// Single access tokens are revoked by jti until they expire on their own,
+// all tokens of a user are revoked by a cutoff: anything issued before it is rejected.
+const (
+	revokedAccessTokenKeyPrefix = "jti:"
+	revokedUserKeyPrefix        = "rvu:"
+)
+
+// revokeAccessToken denylists the jti of claims until the token expires.
+func (s *service) revokeAccessToken(claims UserClaims) error {
+	if claims.ID == "" {
+		return nil
+	}
+
+	expireIn := 0
+	if claims.ExpiresAt != nil {
+		expireIn = int(time.Until(claims.ExpiresAt.Time).Seconds()) + 1
+		if expireIn <= 0 {
+			return nil
+		}
+	}
+
+	return s.rtCache.Set([]byte(revokedAccessTokenKeyPrefix+claims.ID), []byte{1}, expireIn)
+}
+
+// revokeUserTokens rejects every access token and token family of userUUID issued before at.
+// The cutoff is kept until the longest lived token issued before it has expired.
+func (s *service) revokeUserTokens(userUUID string, at time.Time) error {
+	cfg := config.GetConfig().JWT
+	keepFor := cfg.SessionLifetime
+	if cfg.AccessTokenTTL > keepFor {
+		keepFor = cfg.AccessTokenTTL
+	}
+
+	cutoff := strconv.FormatInt(at.UnixNano(), 10)
+	return s.rtCache.Set([]byte(revokedUserKeyPrefix+userUUID), []byte(cutoff), expireInUntil(at.Add(keepFor).Unix()))
+}
+
+// userTokensCutoff returns the time before which tokens of userUUID are revoked.
+func (s *service) userTokensCutoff(userUUID string) (time.Time, bool) {
+	value, err := s.rtCache.Get([]byte(revokedUserKeyPrefix + userUUID))
+	if err != nil {
+		return time.Time{}, false
+	}
+	nanos, err := strconv.ParseInt(string(value), 10, 64)
+	if err != nil {
+		s.logger.Errorf("invalid token revocation cutoff for user %s. error: %s", userUUID, err)
+		return time.Time{}, false
+	}
+
+	return time.Unix(0, nanos), true
+}
+
+func (s *service) isAccessTokenRevoked(claims UserClaims) bool {
+	if _, err := s.rtCache.Get([]byte(revokedAccessTokenKeyPrefix + claims.ID)); err == nil {
+		return true
+	}
+
+	cutoff, ok := s.userTokensCutoff(claims.UUID)
+	if !ok {
+		return false
+	}
+	// iat has second precision, so compare at that precision too.
+	return claims.IssuedAt == nil || claims.IssuedAt.Unix() < cutoff.Unix()
+}
+
+func (s *service) isTokenFamilyRevoked(family tokenFamily) bool {
+	cutoff, ok := s.userTokensCutoff(family.UserUUID)
+	return ok && family.IssuedAt < cutoff.UnixNano()
+}
+
+func (s *service) VerifyAccessToken(token string) (UserClaims, error) {
+	claims, err := s.parseAccessToken(token)
+	if err != nil {
+		return claims, err
+	}
+
+	if s.isAccessTokenRevoked(claims) {
+		return claims, apperror.UnauthorizedError("access token is revoked")
+	}
+
+	return claims, nil
+}
+
+func (s *service) Logout(claims UserClaims, rt RT) error {
+	if rt.RefreshToken != "" {
+		if err := s.revokeRefreshToken(claims.UUID, rt.RefreshToken); err != nil {
+			return err
+		}
+	}
+
+	return s.revokeAccessToken(claims)
+}
+
+// revokeRefreshToken revokes the token family of token if it belongs to userUUID.
+// Unknown or already expired tokens are ignored.
+func (s *service) revokeRefreshToken(userUUID, token string) error {
+	var refresh refreshToken
+	if err := s.getCacheJSON(refreshTokenKeyPrefix+token, &refresh); err != nil {
+		return nil
+	}
+
+	unlock := s.families.Lock(refresh.FamilyID)
+	defer unlock()
+
+	var family tokenFamily
+	if err := s.getCacheJSON(tokenFamilyKeyPrefix+refresh.FamilyID, &family); err != nil {
+		return nil
+	}
+	if family.UserUUID != userUUID {
+		return apperror.UnauthorizedError("refresh token does not belong to the authenticated user")
+	}
+
+	return s.revokeTokenFamily(&family)
+}
+
+func (s *service) LogoutAll(claims UserClaims) error {
+	s.logger.Infof("revoke all tokens of user %s", claims.UUID)
+	if err := s.revokeUserTokens(claims.UUID, time.Now()); err != nil {
+		return err
+	}
+
+	return s.revokeAccessToken(claims)
+}
@@ -3,7 +3,11 @@ package user
 import (
 	"encoding/json"
 	"fmt"
+	"net"
 	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
 
 	"github.com/julienschmidt/httprouter"
 	"github.com/senizdegen/sdu-housing/user-service/internal/apperror"
@@ -13,17 +17,129 @@ import (
 const (
 	usersURL = "/api/users"
 	userURL  = "/api/users/:uuid"
+
+	userRestoreURL     = "/api/users/:uuid/restore"
+	userPasswordURL    = "/api/users/:uuid/password"
+	userTOTPURL        = "/api/users/:uuid/mfa/totp"
+	userTOTPConfirmURL = "/api/users/:uuid/mfa/totp/confirm"
+	userSearchURL      = "/api/search/users"
+
+	authLoginURL      = "/api/auth/login"
+	authLoginMFAURL   = "/api/auth/login/mfa"
+	authRefreshURL    = "/api/auth/refresh"
+	authLogoutURL     = "/api/auth/logout"
+	authLogoutAllURL  = "/api/auth/logout-all"
+	authIntrospectURL = "/api/auth/introspect"
+
+	authVerifyPhoneURL       = "/api/auth/verify-phone"
+	authVerifyPhoneResendURL = "/api/auth/verify-phone/resend"
+	authPasswordForgotURL    = "/api/auth/password/forgot"
+	authPasswordResetURL     = "/api/auth/password/reset"
+	authLockoutsURL          = "/api/auth/lockouts"
 )
 
 type Handler struct {
 	Logger      logging.Logger
 	UserService Service
+	// LegacyGetLogin registers the deprecated query string login on GET /api/users.
+	LegacyGetLogin bool
+	// IntrospectionClients maps client ids to the secrets of services allowed to introspect tokens.
+	IntrospectionClients map[string]string
+	// TrustedProxies is how many proxies in front of the service append to X-Forwarded-For.
+	// Zero ignores the header.
+	TrustedProxies int
+}
+
+// route binds a handler to a method and path. Routes without policy are public,
+// the others require authentication and are checked against the policy.
+type route struct {
+	method  string
+	path    string
+	handler apperror.AppHandler
+	policy  *Policy
+}
+
+func (h *Handler) routes() []route {
+	routes := []route{
+		{http.MethodGet, userURL, h.GetUser, &ownerOrAdmin},
+		{http.MethodPatch, userURL, h.UpdateUser, &ownerOrAdmin},
+		{http.MethodDelete, userURL, h.DeleteUser, &ownerOrAdmin},
+		{http.MethodPost, userRestoreURL, h.RestoreUser, &adminOnly},
+		{http.MethodPost, userPasswordURL, h.ChangePassword, &ownerOnly},
+		{http.MethodPost, userTOTPURL, h.EnrollTOTP, &ownerOnly},
+		{http.MethodPost, userTOTPConfirmURL, h.ConfirmTOTP, &ownerOnly},
+		{http.MethodPost, usersURL, h.CreateUser, nil},
+		{http.MethodGet, userSearchURL, h.SearchUsers, &staffOrAdmin},
+
+		{http.MethodPost, authLoginURL, h.Login, nil},
+		{http.MethodPost, authLoginMFAURL, h.LoginMFA, nil},
+		{http.MethodPost, authRefreshURL, h.RefreshToken, nil},
+		{http.MethodPost, authLogoutURL, h.Logout, &anyUser},
+		{http.MethodPost, authLogoutAllURL, h.LogoutAll, &anyUser},
+		{http.MethodPost, authIntrospectURL, h.Introspect, nil},
+		{http.MethodPost, authVerifyPhoneURL, h.VerifyPhone, nil},
+		{http.MethodPost, authVerifyPhoneResendURL, h.ResendVerificationCode, nil},
+		{http.MethodPost, authPasswordForgotURL, h.ForgotPassword, nil},
+		{http.MethodPost, authPasswordResetURL, h.ResetPassword, nil},
+		{http.MethodDelete, authLockoutsURL, h.ClearLockout, &adminOnly},
+	}
+	if h.LegacyGetLogin {
+		// GET /api/users is shared with the listing until the legacy login is removed.
+		routes = append(routes, route{http.MethodGet, usersURL, h.legacyLoginOr(h.protect(staffOrAdmin, h.ListUsers)), nil})
+	} else {
+		routes = append(routes, route{http.MethodGet, usersURL, h.ListUsers, &staffOrAdmin})
+	}
+
+	return routes
 }
 
 func (h *Handler) Register(router *httprouter.Router) {
-	router.HandlerFunc(http.MethodGet, userURL, apperror.Middleware(h.GetUser))
-	router.HandlerFunc(http.MethodGet, usersURL, apperror.Middleware(h.GetUserByPhoneNumberAndPassword))
-	router.HandlerFunc(http.MethodPost, usersURL, apperror.Middleware(h.CreateUser))
+	for _, rt := range h.routes() {
+		handler := rt.handler
+		if rt.policy != nil {
+			handler = h.protect(*rt.policy, handler)
+		}
+		router.HandlerFunc(rt.method, rt.path, apperror.Middleware(handler))
+	}
+}
+
+// protect requires an authenticated user allowed by policy to call next.
+func (h *Handler) protect(policy Policy, next apperror.AppHandler) apperror.AppHandler {
+	return Authenticate(h.UserService)(Authorize(policy)(next))
+}
+
+// legacyLoginOr serves the deprecated query string login when credentials are in the query and next otherwise.
+func (h *Handler) legacyLoginOr(next apperror.AppHandler) apperror.AppHandler {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		query := r.URL.Query()
+		if query.Has("phone_number") || query.Has("password") {
+			return h.GetUserByPhoneNumberAndPassword(w, r)
+		}
+		return next(w, r)
+	}
+}
+
+// clientIP returns the address of the client that sent r.
+func (h *Handler) clientIP(r *http.Request) string {
+	if h.TrustedProxies > 0 {
+		// Every proxy appends the address it received the request from, entries to the left of
+		// those appended by our proxies were sent by the client and can be forged.
+		var forwarded []string
+		for _, value := range r.Header.Values("X-Forwarded-For") {
+			forwarded = append(forwarded, strings.Split(value, ",")...)
+		}
+		if i := len(forwarded) - h.TrustedProxies; i >= 0 {
+			if ip := strings.TrimSpace(forwarded[i]); ip != "" {
+				return ip
+			}
+		}
+	}
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
 }
 
 /*
@@ -43,6 +159,8 @@ func (h *Handler) GetUser(w http.ResponseWriter, r *http.Request) error {
 	if err != nil {
 		return err
 	}
+	w.Header().Set("ETag", user.ETag())
+
 	h.Logger.Debug("marshal user")
 	userBytes, err := json.Marshal(user)
 
@@ -56,9 +174,13 @@ func (h *Handler) GetUser(w http.ResponseWriter, r *http.Request) error {
 	return nil
 }
 
+// GetUserByPhoneNumberAndPassword is the deprecated query string login.
+// Use POST /api/auth/login instead, it keeps credentials out of URLs and access logs.
 func (h *Handler) GetUserByPhoneNumberAndPassword(w http.ResponseWriter, r *http.Request) error {
-	h.Logger.Info("GET USER BY PHONE NUMBER AND PASSWORD")
+	h.Logger.Warn("GET USER BY PHONE NUMBER AND PASSWORD (deprecated)")
 	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Deprecation", "true")
+	w.Header().Set("Link", fmt.Sprintf("<%s>; rel=\"successor-version\"", authLoginURL))
 
 	h.Logger.Debug("get phone number and password from URL")
 	phoneNumber := r.URL.Query().Get("phone_number")
@@ -67,9 +189,7 @@ func (h *Handler) GetUserByPhoneNumberAndPassword(w http.ResponseWriter, r *http
 		return apperror.BadRequestError("invalid query parameters email or password")
 	}
 
-	h.Logger.Debugf("phone number: %s password: %s", phoneNumber, password)
-
-	user, err := h.UserService.GetByPhoneNumberAndPassword(r.Context(), phoneNumber, password)
+	user, err := h.UserService.GetByPhoneNumberAndPassword(r.Context(), phoneNumber, password, h.clientIP(r))
 	if err != nil {
 		return err
 	}
@@ -110,7 +230,287 @@ func (h *Handler) CreateUser(w http.ResponseWriter, r *http.Request) error {
 	w.WriteHeader(http.StatusCreated)
 	w.Write(userBytes)
 
-	h.Logger.Tracef("token: %s", u.JWTToken)
+	return nil
+}
+
+func (h *Handler) UpdateUser(w http.ResponseWriter, r *http.Request) error {
+	h.Logger.Info("UPDATE USER")
+	w.Header().Set("Content-Type", "application/json")
+
+	h.Logger.Debug("get uuid from context")
+	params := r.Context().Value(httprouter.ParamsKey).(httprouter.Params)
+	userUUID := params.ByName("uuid")
+
+	h.Logger.Debug("decode update user dto")
+	var upUser UpdateUserDTO
+	defer r.Body.Close()
+	if err := json.NewDecoder(r.Body).Decode(&upUser); err != nil {
+		return apperror.BadRequestError("invalid JSON scheme. check swagger API")
+	}
+
+	u, err := h.UserService.Update(r.Context(), userUUID, upUser, r.Header.Get("If-Match"))
+	if err != nil {
+		return err
+	}
+	w.Header().Set("ETag", u.ETag())
+
+	userBytes, err := json.Marshal(u)
+	if err != nil {
+		return fmt.Errorf("failed to marshall user. error: %w", err)
+	}
+
+	w.WriteHeader(http.StatusOK)
+	w.Write(userBytes)
+
+	return nil
+}
+
+func (h *Handler) DeleteUser(w http.ResponseWriter, r *http.Request) error {
+	h.Logger.Info("DELETE USER")
+
+	h.Logger.Debug("get uuid from context")
+	params := r.Context().Value(httprouter.ParamsKey).(httprouter.Params)
+	userUUID := params.ByName("uuid")
+
+	if err := h.UserService.Delete(r.Context(), userUUID); err != nil {
+		return err
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+
+	return nil
+}
+
+func (h *Handler) RestoreUser(w http.ResponseWriter, r *http.Request) error {
+	h.Logger.Info("RESTORE USER")
+	w.Header().Set("Content-Type", "application/json")
+
+	h.Logger.Debug("get uuid from context")
+	params := r.Context().Value(httprouter.ParamsKey).(httprouter.Params)
+	userUUID := params.ByName("uuid")
+
+	u, err := h.UserService.Restore(r.Context(), userUUID)
+	if err != nil {
+		return err
+	}
+	w.Header().Set("ETag", u.ETag())
+
+	userBytes, err := json.Marshal(u)
+	if err != nil {
+		return fmt.Errorf("failed to marshall user. error: %w", err)
+	}
+
+	w.WriteHeader(http.StatusOK)
+	w.Write(userBytes)
+
+	return nil
+}
+
+// ChangePassword sets a new password for the authenticated user. Other sessions are logged out,
+// the response carries a new token pair for the caller.
+func (h *Handler) ChangePassword(w http.ResponseWriter, r *http.Request) error {
+	h.Logger.Info("CHANGE PASSWORD")
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+
+	h.Logger.Debug("get uuid from context")
+	params := r.Context().Value(httprouter.ParamsKey).(httprouter.Params)
+	userUUID := params.ByName("uuid")
+
+	h.Logger.Debug("decode change password dto")
+	var dto ChangePasswordDTO
+	defer r.Body.Close()
+	if err := json.NewDecoder(r.Body).Decode(&dto); err != nil {
+		return apperror.BadRequestError("invalid JSON scheme. check swagger API")
+	}
+	if dto.CurrentPassword == "" {
+		return apperror.BadRequestError("current_password is required")
+	}
+	dto.ClientIP = h.clientIP(r)
+
+	pair, err := h.UserService.ChangePassword(r.Context(), userUUID, dto)
+	if err != nil {
+		return err
+	}
+
+	pairBytes, err := json.Marshal(pair)
+	if err != nil {
+		return fmt.Errorf("failed to marshall token pair. error: %w", err)
+	}
+
+	w.WriteHeader(http.StatusOK)
+	w.Write(pairBytes)
+
+	return nil
+}
+
+// EnrollTOTP starts two-factor authentication for the user, the returned otpauth:// URI
+// is added to an authenticator app and confirmed with ConfirmTOTP.
+func (h *Handler) EnrollTOTP(w http.ResponseWriter, r *http.Request) error {
+	h.Logger.Info("ENROLL TOTP")
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+
+	h.Logger.Debug("get uuid from context")
+	params := r.Context().Value(httprouter.ParamsKey).(httprouter.Params)
+	userUUID := params.ByName("uuid")
+
+	enrollment, err := h.UserService.EnrollTOTP(r.Context(), userUUID)
+	if err != nil {
+		return err
+	}
+
+	enrollmentBytes, err := json.Marshal(enrollment)
+	if err != nil {
+		return fmt.Errorf("failed to marshall totp enrollment. error: %w", err)
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	w.Write(enrollmentBytes)
+
+	return nil
+}
+
+// ConfirmTOTP enables two-factor authentication with a code of the enrolled secret
+// and returns the recovery codes, which are not shown again.
+func (h *Handler) ConfirmTOTP(w http.ResponseWriter, r *http.Request) error {
+	h.Logger.Info("CONFIRM TOTP")
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+
+	h.Logger.Debug("get uuid from context")
+	params := r.Context().Value(httprouter.ParamsKey).(httprouter.Params)
+	userUUID := params.ByName("uuid")
+
+	h.Logger.Debug("decode confirm totp dto")
+	var dto ConfirmTOTPDTO
+	defer r.Body.Close()
+	if err := json.NewDecoder(r.Body).Decode(&dto); err != nil {
+		return apperror.BadRequestError("invalid JSON scheme. check swagger API")
+	}
+	if dto.Code == "" {
+		return apperror.BadRequestError("code is required")
+	}
+
+	codes, err := h.UserService.ConfirmTOTP(r.Context(), userUUID, dto)
+	if err != nil {
+		return err
+	}
+
+	codesBytes, err := json.Marshal(codes)
+	if err != nil {
+		return fmt.Errorf("failed to marshall recovery codes. error: %w", err)
+	}
+
+	w.WriteHeader(http.StatusOK)
+	w.Write(codesBytes)
+
+	return nil
+}
+
+func (h *Handler) ListUsers(w http.ResponseWriter, r *http.Request) error {
+	h.Logger.Info("LIST USERS")
+	w.Header().Set("Content-Type", "application/json")
+
+	h.Logger.Debug("parse user filter from URL")
+	filter, err := userFilterFromQuery(r.URL.Query())
+	if err != nil {
+		return err
+	}
+
+	page, err := h.UserService.Find(r.Context(), filter)
+	if err != nil {
+		return err
+	}
+
+	pageBytes, err := json.Marshal(page)
+	if err != nil {
+		return fmt.Errorf("failed to marshall users. error: %w", err)
+	}
+
+	w.WriteHeader(http.StatusOK)
+	w.Write(pageBytes)
+
+	return nil
+}
+
+// userFilterFromQuery parses
+// role (repeated or comma separated), created_from, created_to (unix seconds),
+// deleted (exclude, only, include), sort (created_at, -created_at), cursor and limit.
+func userFilterFromQuery(query url.Values) (filter UserFilter, err error) {
+	for _, roles := range query["role"] {
+		for _, role := range strings.Split(roles, ",") {
+			if role = strings.TrimSpace(role); role != "" {
+				filter.Roles = append(filter.Roles, role)
+			}
+		}
+	}
+
+	if filter.CreatedFrom, err = int64Query(query, "created_from"); err != nil {
+		return filter, err
+	}
+	if filter.CreatedTo, err = int64Query(query, "created_to"); err != nil {
+		return filter, err
+	}
+
+	filter.Deleted = query.Get("deleted")
+	filter.Sort = query.Get("sort")
+	filter.Cursor = query.Get("cursor")
+
+	limit, err := int64Query(query, "limit")
+	if err != nil {
+		return filter, err
+	}
+	filter.Limit = int(limit)
+
+	return filter, filter.Validate()
+}
+
+func int64Query(query url.Values, key string) (int64, error) {
+	value := query.Get(key)
+	if value == "" {
+		return 0, nil
+	}
+
+	n, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || n < 0 {
+		return 0, apperror.BadRequestError(fmt.Sprintf("%s must be a non-negative integer", key))
+	}
+	return n, nil
+}
+
+func (h *Handler) SearchUsers(w http.ResponseWriter, r *http.Request) error {
+	h.Logger.Info("SEARCH USERS")
+	w.Header().Set("Content-Type", "application/json")
+
+	h.Logger.Debug("parse search query from URL")
+	query := r.URL.Query()
+	offset, err := int64Query(query, "offset")
+	if err != nil {
+		return err
+	}
+	limit, err := int64Query(query, "limit")
+	if err != nil {
+		return err
+	}
+
+	searchQuery, err := NewSearchQuery(query.Get("q"), int(offset), int(limit))
+	if err != nil {
+		return err
+	}
+
+	result, err := h.UserService.Search(r.Context(), searchQuery)
+	if err != nil {
+		return err
+	}
+
+	resultBytes, err := json.Marshal(result)
+	if err != nil {
+		return fmt.Errorf("failed to marshall search result. error: %w", err)
+	}
+
+	w.WriteHeader(http.StatusOK)
+	w.Write(resultBytes)
 
 	return nil
 }
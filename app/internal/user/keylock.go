@@ -0,0 +1,43 @@
+package user
+
+import "sync"
+
+// keyedMutex serializes work per key, so work on unrelated keys does not wait.
+// The zero value is ready to use.
+type keyedMutex struct {
+	mu    sync.Mutex
+	locks map[string]*keyedLock
+}
+
+type keyedLock struct {
+	sync.Mutex
+	// holders counts the goroutines holding or waiting for the lock, it is dropped at zero.
+	holders int
+}
+
+// Lock locks key and returns the function that unlocks it.
+func (m *keyedMutex) Lock(key string) (unlock func()) {
+	m.mu.Lock()
+	if m.locks == nil {
+		m.locks = map[string]*keyedLock{}
+	}
+	l, ok := m.locks[key]
+	if !ok {
+		l = &keyedLock{}
+		m.locks[key] = l
+	}
+	l.holders++
+	m.mu.Unlock()
+
+	l.Lock()
+	return func() {
+		l.Unlock()
+
+		m.mu.Lock()
+		l.holders--
+		if l.holders == 0 {
+			delete(m.locks, key)
+		}
+		m.mu.Unlock()
+	}
+}
@@ -0,0 +1,188 @@
+package user
+
+import (
+	"context"
+	"time"
+
+	"github.com/senizdegen/sdu-housing/user-service/internal/apperror"
+	"github.com/senizdegen/sdu-housing/user-service/internal/config"
+)
+
+// Failed logins are counted per phone number and per client IP in a cache of their own,
+// so a login spray cannot evict revoked tokens or refresh tokens.
+// After FreeAttempts failures of a phone number every further attempt has to wait twice as long
+// as the previous one, after LockoutThreshold failures the phone number is locked.
+// Client IPs trying many phone numbers are locked after IPLockoutThreshold failures.
+const (
+	loginFailuresPhoneKeyPrefix = "lfp:"
+	loginFailuresIPKeyPrefix    = "lfi:"
+)
+
+type loginFailures struct {
+	Count int `json:"count"`
+	// LastAt is the time of the last failure in unix nanoseconds.
+	LastAt int64 `json:"last_at"`
+}
+
+// startLoginAttempt returns an error carrying the time to wait if phoneNumber or clientIP may not try to log in now.
+// Otherwise the attempt is counted as failed right away, so parallel attempts cannot all pass the check,
+// and loginAttemptSucceeded takes it back.
+func (s *service) startLoginAttempt(phoneNumber, clientIP string) error {
+	cfg := config.GetConfig().Lockout
+	now := time.Now()
+
+	s.lockoutMu.Lock()
+	defer s.lockoutMu.Unlock()
+
+	var failures loginFailures
+	if err := getJSON(s.lockoutCache, loginFailuresPhoneKeyPrefix+phoneNumber, &failures); err == nil {
+		lastAt := time.Unix(0, failures.LastAt)
+		if failures.Count >= cfg.LockoutThreshold {
+			if wait := lastAt.Add(cfg.LockoutDuration).Sub(now); wait > 0 {
+				return apperror.LockedError("too many failed logins, the account is temporarily locked", wait)
+			}
+		} else if failures.Count >= cfg.FreeAttempts {
+			if wait := lastAt.Add(loginBackoff(cfg, failures.Count)).Sub(now); wait > 0 {
+				return apperror.TooManyRequestsError("too many failed logins, wait before trying again", wait)
+			}
+		}
+	}
+
+	if clientIP != "" {
+		failures = loginFailures{}
+		if err := getJSON(s.lockoutCache, loginFailuresIPKeyPrefix+clientIP, &failures); err == nil && failures.Count >= cfg.IPLockoutThreshold {
+			if wait := time.Unix(0, failures.LastAt).Add(cfg.LockoutDuration).Sub(now); wait > 0 {
+				return apperror.TooManyRequestsError("too many failed logins from this address, wait before trying again", wait)
+			}
+		}
+	}
+
+	s.addLoginFailures(loginFailuresPhoneKeyPrefix+phoneNumber, 1, cfg)
+	if clientIP != "" {
+		s.addLoginFailures(loginFailuresIPKeyPrefix+clientIP, 1, cfg)
+	}
+
+	return nil
+}
+
+// loginBackoff returns the wait after count failures of a phone number, doubling from BaseDelay up to MaxDelay.
+func loginBackoff(cfg config.Lockout, count int) time.Duration {
+	delay := cfg.BaseDelay
+	for i := cfg.FreeAttempts; i < count && delay < cfg.MaxDelay; i++ {
+		delay *= 2
+	}
+	if delay > cfg.MaxDelay {
+		delay = cfg.MaxDelay
+	}
+	return delay
+}
+
+// loginAttemptFailed reports the lockouts the failed attempt of phoneNumber from clientIP caused.
+// The attempt itself was counted by startLoginAttempt.
+func (s *service) loginAttemptFailed(phoneNumber, clientIP string) {
+	cfg := config.GetConfig().Lockout
+
+	s.lockoutMu.Lock()
+	defer s.lockoutMu.Unlock()
+
+	var failures loginFailures
+	if err := getJSON(s.lockoutCache, loginFailuresPhoneKeyPrefix+phoneNumber, &failures); err == nil && failures.Count == cfg.LockoutThreshold {
+		s.logger.
+			WithField("event", "account_locked").
+			WithField("phone_number", phoneNumber).
+			WithField("client_ip", clientIP).
+			Warn("security event: too many failed logins, locking phone number")
+	}
+
+	if clientIP == "" {
+		return
+	}
+	failures = loginFailures{}
+	if err := getJSON(s.lockoutCache, loginFailuresIPKeyPrefix+clientIP, &failures); err == nil && failures.Count == cfg.IPLockoutThreshold {
+		s.logger.
+			WithField("event", "client_ip_locked").
+			WithField("client_ip", clientIP).
+			Warn("security event: too many failed logins, locking client ip")
+	}
+}
+
+// loginAttemptSucceeded takes back the attempt counted by startLoginAttempt.
+// resetPhone also forgets the earlier failures of phoneNumber. The counter of the client IP is only
+// taken back, logging into one account must not unlock guessing others.
+func (s *service) loginAttemptSucceeded(phoneNumber, clientIP string, resetPhone bool) {
+	cfg := config.GetConfig().Lockout
+
+	s.lockoutMu.Lock()
+	defer s.lockoutMu.Unlock()
+
+	if resetPhone {
+		s.lockoutCache.Del([]byte(loginFailuresPhoneKeyPrefix + phoneNumber))
+	} else {
+		s.addLoginFailures(loginFailuresPhoneKeyPrefix+phoneNumber, -1, cfg)
+	}
+	if clientIP != "" {
+		s.addLoginFailures(loginFailuresIPKeyPrefix+clientIP, -1, cfg)
+	}
+}
+
+// addLoginFailures adds delta to the failures counted under key. Only added failures move LastAt.
+// The caller holds lockoutMu.
+func (s *service) addLoginFailures(key string, delta int, cfg config.Lockout) {
+	var failures loginFailures
+	if err := getJSON(s.lockoutCache, key, &failures); err != nil {
+		failures = loginFailures{}
+	}
+
+	failures.Count += delta
+	if failures.Count <= 0 {
+		s.lockoutCache.Del([]byte(key))
+		return
+	}
+	if delta > 0 {
+		failures.LastAt = time.Now().UnixNano()
+	}
+
+	// Counters are forgotten after a quiet Window, but never before a lockout is over.
+	keepFor := cfg.Window
+	if cfg.LockoutDuration > keepFor {
+		keepFor = cfg.LockoutDuration
+	}
+	expiresAt := time.Unix(0, failures.LastAt).Add(keepFor).Unix()
+	if err := setJSON(s.lockoutCache, key, failures, expireInUntil(expiresAt)); err != nil {
+		s.logger.Errorf("failed to store login failures. error: %s", err)
+	}
+}
+
+// lockoutPhoneNumber returns the phone number the failed logins of u are counted under,
+// normalized like the one a login is started with.
+func lockoutPhoneNumber(u User) string {
+	normalized, err := normalizePhoneNumber(u.PhoneNumber)
+	if err != nil {
+		return u.PhoneNumber
+	}
+	return normalized
+}
+
+// ClearLockout forgets the failed logins of phoneNumber and clientIP, either may be empty.
+func (s *service) ClearLockout(ctx context.Context, phoneNumber, clientIP string) (err error) {
+	if phoneNumber == "" && clientIP == "" {
+		return apperror.BadRequestError("phone_number or ip is required")
+	}
+
+	s.lockoutMu.Lock()
+	defer s.lockoutMu.Unlock()
+
+	if phoneNumber != "" {
+		if phoneNumber, err = normalizePhoneNumber(phoneNumber); err != nil {
+			return err
+		}
+		s.lockoutCache.Del([]byte(loginFailuresPhoneKeyPrefix + phoneNumber))
+		s.logger.Infof("login lockout of phone number %s cleared", phoneNumber)
+	}
+	if clientIP != "" {
+		s.lockoutCache.Del([]byte(loginFailuresIPKeyPrefix + clientIP))
+		s.logger.Infof("login lockout of client ip %s cleared", clientIP)
+	}
+
+	return nil
+}
@@ -0,0 +1,234 @@
+package user
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/senizdegen/sdu-housing/user-service/internal/apperror"
+	"github.com/senizdegen/sdu-housing/user-service/internal/config"
+	"github.com/senizdegen/sdu-housing/user-service/pkg/totp"
+)
+
+// Pending TOTP enrollments and MFA login challenges live in the same cache as refresh tokens.
+const (
+	totpEnrollmentKeyPrefix = "mfae:"
+	mfaChallengeKeyPrefix   = "mfa:"
+)
+
+const recoveryCodeLength = 10
+
+var errInvalidMFACode = apperror.UnauthorizedError("code is invalid")
+
+// mfaChallenge is the first login step of a user with two-factor authentication, it was password checked.
+type mfaChallenge struct {
+	UserUUID  string `json:"user_uuid"`
+	Attempts  int    `json:"attempts"`
+	ExpiresAt int64  `json:"expires_at"`
+}
+
+// EnrollTOTP generates a TOTP secret for the user, which becomes active once confirmed with ConfirmTOTP.
+func (s *service) EnrollTOTP(ctx context.Context, uuid string) (enrollment TOTPEnrollment, err error) {
+	u, err := s.GetOne(ctx, uuid)
+	if err != nil {
+		return enrollment, err
+	}
+	if !canEnrollMFA(u.Role) {
+		return enrollment, apperror.ForbiddenError("two-factor authentication is available to landlords and admins")
+	}
+	if u.TOTPEnabled {
+		return enrollment, apperror.ConflictError("two-factor authentication is already enabled")
+	}
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		return enrollment, err
+	}
+
+	cfg := config.GetConfig().MFA
+	expiresAt := time.Now().Add(cfg.EnrollmentTTL).Unix()
+	if err = s.setCacheJSON(totpEnrollmentKeyPrefix+u.UUID, secret, expireInUntil(expiresAt)); err != nil {
+		return enrollment, fmt.Errorf("failed to store totp enrollment. error: %w", err)
+	}
+
+	return TOTPEnrollment{
+		Secret: secret,
+		URI:    totp.URI(cfg.Issuer, u.PhoneNumber, secret),
+	}, nil
+}
+
+// ConfirmTOTP enables two-factor authentication if dto.Code matches the pending secret
+// and returns the recovery codes of the user.
+func (s *service) ConfirmTOTP(ctx context.Context, uuid string, dto ConfirmTOTPDTO) (codes RecoveryCodes, err error) {
+	var secret string
+	if err = s.getCacheJSON(totpEnrollmentKeyPrefix+uuid, &secret); err != nil {
+		return codes, apperror.BadRequestError("no pending two-factor enrollment, start a new one")
+	}
+
+	step, ok := totp.Validate(secret, dto.Code, time.Now())
+	if !ok {
+		return codes, apperror.BadRequestError("code is invalid")
+	}
+
+	u, err := s.GetOne(ctx, uuid)
+	if err != nil {
+		return codes, err
+	}
+	if u.TOTPEnabled {
+		return codes, apperror.ConflictError("two-factor authentication is already enabled")
+	}
+
+	plain, hashes, err := generateRecoveryCodes(config.GetConfig().MFA.RecoveryCodes)
+	if err != nil {
+		return codes, err
+	}
+
+	u.TOTPEnabled = true
+	u.TOTPSecret = secret
+	u.TOTPLastStep = step
+	u.RecoveryCodes = hashes
+	u.UpdatedAt = time.Now().Unix()
+	if err = s.storage.Update(ctx, u); err != nil {
+		if errors.Is(err, apperror.ErrNotFound) || errors.Is(err, apperror.ErrPreconditionFailed) {
+			return codes, err
+		}
+		return codes, fmt.Errorf("failed to enable two-factor authentication. error: %w", err)
+	}
+	s.rtCache.Del([]byte(totpEnrollmentKeyPrefix + uuid))
+
+	s.logger.Infof("user %s enabled two-factor authentication", u.UUID)
+	return RecoveryCodes{RecoveryCodes: plain}, nil
+}
+
+// startMFAChallenge returns the token that completes the login of u at LoginMFA.
+func (s *service) startMFAChallenge(u User) (string, error) {
+	token := make([]byte, 32)
+	if _, err := rand.Read(token); err != nil {
+		return "", fmt.Errorf("failed to generate mfa token. error: %w", err)
+	}
+	mfaToken := hex.EncodeToString(token)
+
+	challenge := mfaChallenge{
+		UserUUID:  u.UUID,
+		ExpiresAt: time.Now().Add(config.GetConfig().MFA.ChallengeTTL).Unix(),
+	}
+	if err := s.setCacheJSON(mfaChallengeKeyPrefix+mfaToken, challenge, expireInUntil(challenge.ExpiresAt)); err != nil {
+		return "", fmt.Errorf("failed to store mfa challenge. error: %w", err)
+	}
+
+	return mfaToken, nil
+}
+
+// LoginMFA completes a login started by Login with a TOTP or recovery code.
+// Wrong codes count as failed logins of the user, and the challenge is discarded after too many of them.
+func (s *service) LoginMFA(ctx context.Context, dto MFALoginDTO) (resp LoginResponse, err error) {
+	unlock := s.challenges.Lock(dto.MFAToken)
+	defer unlock()
+
+	var challenge mfaChallenge
+	if err = s.getCacheJSON(mfaChallengeKeyPrefix+dto.MFAToken, &challenge); err != nil || time.Now().Unix() >= challenge.ExpiresAt {
+		return resp, apperror.UnauthorizedError("mfa token is invalid or expired, log in again")
+	}
+
+	u, err := s.GetOne(ctx, challenge.UserUUID)
+	if err != nil {
+		if errors.Is(err, apperror.ErrNotFound) {
+			return resp, apperror.UnauthorizedError("mfa token is invalid or expired, log in again")
+		}
+		return resp, err
+	}
+	phoneNumber := lockoutPhoneNumber(u)
+	if err = s.startLoginAttempt(phoneNumber, dto.ClientIP); err != nil {
+		return resp, err
+	}
+
+	ok := false
+	if dto.RecoveryCode != "" {
+		ok = u.useRecoveryCode(dto.RecoveryCode)
+	} else if step, valid := totp.Validate(u.TOTPSecret, dto.Code, time.Now()); valid && step > u.TOTPLastStep {
+		u.TOTPLastStep = step
+		ok = true
+	}
+
+	if !ok {
+		s.loginAttemptFailed(phoneNumber, dto.ClientIP)
+		challenge.Attempts++
+		if challenge.Attempts >= config.GetConfig().MFA.MaxAttempts {
+			s.logger.
+				WithField("event", "mfa_attempts_exceeded").
+				WithField("user_uuid", u.UUID).
+				Warn("security event: too many wrong mfa codes, discarding challenge")
+			s.rtCache.Del([]byte(mfaChallengeKeyPrefix + dto.MFAToken))
+			return resp, apperror.UnauthorizedError("too many wrong codes, log in again")
+		}
+		if err = s.setCacheJSON(mfaChallengeKeyPrefix+dto.MFAToken, challenge, expireInUntil(challenge.ExpiresAt)); err != nil {
+			return resp, fmt.Errorf("failed to store mfa challenge. error: %w", err)
+		}
+		return resp, errInvalidMFACode
+	}
+
+	// The accepted step or recovery code must be stored before the login succeeds, or it could be replayed.
+	// Challenges of the same user are not locked against each other, the versioned update rejects the later one.
+	if err = s.storage.Update(ctx, u); err != nil {
+		if errors.Is(err, apperror.ErrPreconditionFailed) {
+			return resp, errInvalidMFACode
+		}
+		return resp, fmt.Errorf("failed to store used mfa code. error: %w", err)
+	}
+	u.Version++
+
+	// Only now the login of the user succeeded, so its failures are forgotten.
+	s.loginAttemptSucceeded(phoneNumber, dto.ClientIP, true)
+	s.rtCache.Del([]byte(mfaChallengeKeyPrefix + dto.MFAToken))
+
+	s.logger.Info("Generate jwt token")
+	pair, err := s.issueTokenPair(u)
+	if err != nil {
+		return resp, fmt.Errorf("failed to generate token. error: %w", err)
+	}
+
+	return LoginResponse{TokenPair: &pair, User: &u}, nil
+}
+
+// useRecoveryCode removes code from the recovery codes of u and reports whether it was one of them.
+func (u *User) useRecoveryCode(code string) bool {
+	hash := hashRecoveryCode(code)
+	for i, candidate := range u.RecoveryCodes {
+		if subtle.ConstantTimeCompare([]byte(candidate), []byte(hash)) == 1 {
+			u.RecoveryCodes = append(u.RecoveryCodes[:i:i], u.RecoveryCodes[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// generateRecoveryCodes returns n random codes formatted as xxxxx-xxxxx and their hashes.
+func generateRecoveryCodes(n int) (plain, hashes []string, err error) {
+	encoding := base32.StdEncoding.WithPadding(base32.NoPadding)
+	for i := 0; i < n; i++ {
+		random := make([]byte, recoveryCodeLength*5/8)
+		if _, err = rand.Read(random); err != nil {
+			return nil, nil, fmt.Errorf("failed to generate recovery code. error: %w", err)
+		}
+		code := strings.ToLower(encoding.EncodeToString(random))
+		code = code[:recoveryCodeLength/2] + "-" + code[recoveryCodeLength/2:]
+
+		plain = append(plain, code)
+		hashes = append(hashes, hashRecoveryCode(code))
+	}
+	return plain, hashes, nil
+}
+
+// hashRecoveryCode ignores case and dashes, codes are random enough for a plain hash.
+func hashRecoveryCode(code string) string {
+	code = strings.ToLower(strings.ReplaceAll(strings.TrimSpace(code), "-", ""))
+	sum := sha256.Sum256([]byte(code))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,43 @@
+package user
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	"github.com/senizdegen/sdu-housing/user-service/internal/apperror"
+)
+
+type contextKey int
+
+const claimsContextKey contextKey = iota
+
+// ClaimsFromContext returns the claims of the access token the request was authenticated with.
+func ClaimsFromContext(ctx context.Context) (UserClaims, bool) {
+	claims, ok := ctx.Value(claimsContextKey).(UserClaims)
+	return claims, ok
+}
+
+// Authenticate returns a middleware that only lets requests with a valid, unrevoked
+// bearer access token through. The token claims are put into the request context,
+// see ClaimsFromContext. It is meant to be wrapped by apperror.Middleware.
+func Authenticate(s Service) func(next apperror.AppHandler) apperror.AppHandler {
+	return func(next apperror.AppHandler) apperror.AppHandler {
+		return func(w http.ResponseWriter, r *http.Request) error {
+			token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if !ok || token == "" {
+				w.Header().Set("WWW-Authenticate", `Bearer realm="users"`)
+				return apperror.UnauthorizedError("bearer token is required")
+			}
+
+			claims, err := s.VerifyAccessToken(token)
+			if err != nil {
+				w.Header().Set("WWW-Authenticate", `Bearer realm="users", error="invalid_token"`)
+				return err
+			}
+
+			ctx := context.WithValue(r.Context(), claimsContextKey, claims)
+			return next(w, r.WithContext(ctx))
+		}
+	}
+}
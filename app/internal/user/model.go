@@ -1,10 +1,15 @@
 package user
 
 import (
+	"errors"
 	"fmt"
+	"net/url"
+	"strings"
 	"time"
+	"unicode/utf8"
 
-	"golang.org/x/crypto/bcrypt"
+	"github.com/senizdegen/sdu-housing/user-service/internal/apperror"
+	"github.com/senizdegen/sdu-housing/user-service/pkg/phone"
 )
 
 type User struct {
@@ -16,7 +21,51 @@ type User struct {
 	AvatarURL   string `json:"avatar_url" bson:"avatar_url,omitempty"`
 	CreatedAt   int64  `json:"created_at" bson:"created_at,omitempty"`
 	UpdatedAt   int64  `json:"updated_at" bson:"updated_at,omitempty"`
-	JWTToken    string `json:"jwt" bson:"-"`
+	// NameWords are the lowercased words of FullName, set by the storage, names are searched by their prefixes.
+	NameWords []string `json:"-" bson:"name_words,omitempty"`
+	// Status is StatusUnverified until the phone number is confirmed. Users stored before verification have none.
+	Status string `json:"status,omitempty" bson:"status,omitempty"`
+	// PasswordChangedAt is when the password was last changed or reset.
+	// Sessions started before it cannot be refreshed, access tokens issued before it are denylisted.
+	PasswordChangedAt int64 `json:"password_changed_at,omitempty" bson:"password_changed_at,omitempty"`
+	// TOTPEnabled requires a TOTP or recovery code on login, see LoginMFA.
+	TOTPEnabled bool   `json:"totp_enabled,omitempty" bson:"totp_enabled,omitempty"`
+	TOTPSecret  string `json:"-" bson:"totp_secret,omitempty"`
+	// TOTPLastStep is the time step of the last accepted code, codes of earlier steps are replays.
+	TOTPLastStep int64 `json:"-" bson:"totp_last_step,omitempty"`
+	// RecoveryCodes are the hashes of the unused recovery codes.
+	RecoveryCodes []string `json:"-" bson:"recovery_codes,omitempty"`
+	// DeletedAt is set while the account waits for the purge, such users are hidden from lookups.
+	DeletedAt int64 `json:"deleted_at,omitempty" bson:"deleted_at,omitempty"`
+	// Version is incremented on every update, documents stored before versioning have none.
+	Version  int64  `json:"-" bson:"version,omitempty"`
+	JWTToken string `json:"jwt,omitempty" bson:"-"`
+}
+
+const (
+	StatusUnverified = "unverified"
+	StatusActive     = "active"
+)
+
+// PhoneVerified reports whether u has confirmed its phone number.
+func (u User) PhoneVerified() bool {
+	return u.Status != StatusUnverified
+}
+
+// ETag returns the strong entity tag of the stored version of u.
+func (u User) ETag() string {
+	return fmt.Sprintf(`"%d"`, u.Version)
+}
+
+// etagMatches evaluates an If-Match header value against etag using strong comparison.
+func etagMatches(ifMatch, etag string) bool {
+	for _, candidate := range strings.Split(ifMatch, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || candidate == etag {
+			return true
+		}
+	}
+	return false
 }
 
 type CreateUserDTO struct {
@@ -28,37 +77,179 @@ type CreateUserDTO struct {
 	UpdatedAt      time.Time `json:"updated_at"`
 }
 
+// UpdateUserDTO is a partial profile update, nil fields are left unchanged.
+type UpdateUserDTO struct {
+	FullName  *string `json:"full_name"`
+	AvatarURL *string `json:"avatar_url"`
+}
+
+const maxFullNameLength = 100
+
+func (dto UpdateUserDTO) Validate() error {
+	if dto.FullName == nil && dto.AvatarURL == nil {
+		return apperror.BadRequestError("nothing to update")
+	}
+	if dto.FullName != nil {
+		name := strings.TrimSpace(*dto.FullName)
+		if name == "" || utf8.RuneCountInString(name) > maxFullNameLength {
+			return apperror.BadRequestError(fmt.Sprintf("full_name must be 1 to %d characters long", maxFullNameLength))
+		}
+	}
+	// An empty avatar_url removes the avatar.
+	if dto.AvatarURL != nil && *dto.AvatarURL != "" {
+		avatarURL, err := url.Parse(*dto.AvatarURL)
+		if err != nil || (avatarURL.Scheme != "http" && avatarURL.Scheme != "https") || avatarURL.Host == "" {
+			return apperror.BadRequestError("avatar_url must be an absolute http or https URL")
+		}
+	}
+	return nil
+}
+
+// Apply copies the set fields of dto to u.
+func (dto UpdateUserDTO) Apply(u *User) {
+	if dto.FullName != nil {
+		u.FullName = strings.TrimSpace(*dto.FullName)
+	}
+	if dto.AvatarURL != nil {
+		u.AvatarURL = *dto.AvatarURL
+	}
+}
+
+type LoginDTO struct {
+	PhoneNumber string `json:"phone_number"`
+	Password    string `json:"password"`
+	// ClientIP is set by the handler, failed logins are counted per client IP.
+	ClientIP string `json:"-"`
+}
+
+type VerifyPhoneDTO struct {
+	PhoneNumber string `json:"phone_number"`
+	Code        string `json:"code"`
+}
+
+type ResetPasswordDTO struct {
+	PhoneNumber    string `json:"phone_number"`
+	Code           string `json:"code"`
+	Password       string `json:"password"`
+	RepeatPassword string `json:"repeat_password"`
+}
+
+type ChangePasswordDTO struct {
+	CurrentPassword string `json:"current_password"`
+	Password        string `json:"password"`
+	RepeatPassword  string `json:"repeat_password"`
+	// ClientIP is set by the handler, wrong current passwords count as failed logins.
+	ClientIP string `json:"-"`
+}
+
+// SendCodeDTO requests a one-time code to be sent to PhoneNumber.
+type SendCodeDTO struct {
+	PhoneNumber string `json:"phone_number"`
+}
+
+// LoginResponse carries the token pair and the user, or only MFAToken when the user has
+// two-factor authentication enabled and the login has to be completed at POST /api/auth/login/mfa.
+type LoginResponse struct {
+	*TokenPair
+	User     *User  `json:"user,omitempty"`
+	MFAToken string `json:"mfa_token,omitempty"`
+}
+
+// TOTPEnrollment is a TOTP secret waiting to be confirmed with a code.
+type TOTPEnrollment struct {
+	Secret string `json:"secret"`
+	URI    string `json:"otpauth_uri"`
+}
+
+type ConfirmTOTPDTO struct {
+	Code string `json:"code"`
+}
+
+// RecoveryCodes can each be used once instead of a TOTP code. They are only shown once.
+type RecoveryCodes struct {
+	RecoveryCodes []string `json:"recovery_codes"`
+}
+
+// MFALoginDTO completes a login with either a TOTP code or a recovery code.
+type MFALoginDTO struct {
+	MFAToken     string `json:"mfa_token"`
+	Code         string `json:"code"`
+	RecoveryCode string `json:"recovery_code"`
+	// ClientIP is set by the handler, wrong codes count as failed logins.
+	ClientIP string `json:"-"`
+}
+
+// IntrospectionResponse describes an access token, see RFC 7662.
+// Inactive tokens only carry Active. client_id is left out, access tokens are issued to users, not to clients.
+type IntrospectionResponse struct {
+	Active    bool     `json:"active"`
+	TokenType string   `json:"token_type,omitempty"`
+	Subject   string   `json:"sub,omitempty"`
+	Audience  []string `json:"aud,omitempty"`
+	ExpiresAt int64    `json:"exp,omitempty"`
+	IssuedAt  int64    `json:"iat,omitempty"`
+	ID        string   `json:"jti,omitempty"`
+	UUID      string   `json:"uuid,omitempty"`
+	Role      string   `json:"role,omitempty"`
+}
+
+// ErrPhoneNumberTaken is returned when registering a phone number that already has an account.
+var ErrPhoneNumberTaken = apperror.ConflictError("phone number is already registered")
+
+// ErrPhoneNotVerified is returned when an unverified user tries to log in.
+var ErrPhoneNotVerified = apperror.ForbiddenError("phone number is not verified, confirm it with the code sent by SMS")
+
+// normalizePhoneNumber converts phoneNumber to E.164, numbers without country code are from phone.DefaultRegion.
+func normalizePhoneNumber(phoneNumber string) (string, error) {
+	normalized, err := phone.Normalize(phoneNumber, phone.DefaultRegion)
+	if err != nil {
+		return "", apperror.ValidationError(map[string][]string{
+			"phone_number": {"invalid phone number, accepted formats: " + strings.Join(phone.AcceptedFormats, ", ")},
+		})
+	}
+	return normalized, nil
+}
+
 func NewUser(dto CreateUserDTO) User {
 	return User{
 		FullName:    dto.FullName,
 		PhoneNumber: dto.PhoneNumber,
 		Password:    dto.Password,
+		Role:        DefaultRole,
+		Status:      StatusUnverified,
+		Version:     1,
 		CreatedAt:   time.Now().Unix(),
 		UpdatedAt:   time.Now().Unix(),
 	}
 }
 
-func (u *User) CheckPassword(password string) error {
-	err := bcrypt.CompareHashAndPassword([]byte(u.Password), []byte([]byte(password)))
+// PasswordHasher hashes new passwords and verifies stored hashes of any supported algorithm.
+type PasswordHasher interface {
+	Hash(password string) (string, error)
+	Verify(hash, password string) (bool, error)
+	// NeedsRehash reports whether hash should be replaced by a hash made with the current settings.
+	NeedsRehash(hash string) bool
+}
+
+var errPasswordMismatch = errors.New("password does not match")
+
+func (u *User) CheckPassword(hasher PasswordHasher, password string) error {
+	ok, err := hasher.Verify(u.Password, password)
 	if err != nil {
-		return fmt.Errorf("password does not match")
+		return fmt.Errorf("failed to check password. error: %w", err)
+	}
+	if !ok {
+		return errPasswordMismatch
 	}
 	return nil
 }
 
-func (u *User) GeneratePasswordHash() error {
-	pwd, err := generatePasswordHash(u.Password)
+// GeneratePasswordHash replaces the plain text password of u with its hash.
+func (u *User) GeneratePasswordHash(hasher PasswordHasher) error {
+	pwd, err := hasher.Hash(u.Password)
 	if err != nil {
 		return err
 	}
 	u.Password = pwd
 	return nil
 }
-
-func generatePasswordHash(password string) (string, error) {
-	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.MinCost)
-	if err != nil {
-		return "", fmt.Errorf("failed to hash password due to error: %w", err)
-	}
-	return string(hash), nil
-}
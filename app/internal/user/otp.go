@@ -0,0 +1,159 @@
+package user
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"math/big"
+	"time"
+
+	"github.com/senizdegen/sdu-housing/user-service/internal/apperror"
+	"github.com/senizdegen/sdu-housing/user-service/internal/config"
+)
+
+// SMSSender delivers text messages to phone numbers in E.164 format.
+type SMSSender interface {
+	Send(ctx context.Context, phoneNumber, text string) error
+}
+
+// One-time codes live in the same cache as refresh tokens, keyed by purpose and phone number.
+// Only a hash of the code is stored.
+const (
+	otpKeyPrefix         = "otp:"
+	otpCooldownKeyPrefix = "otpc:"
+)
+
+const (
+	otpPurposeVerifyPhone   = "verify_phone"
+	otpPurposeResetPassword = "reset_password"
+)
+
+const otpDigits = 6
+
+var errInvalidCode = apperror.BadRequestError("code is invalid or expired")
+
+type oneTimeCode struct {
+	Hash      string `json:"hash"`
+	Attempts  int    `json:"attempts"`
+	ExpiresAt int64  `json:"expires_at"`
+}
+
+// startCodeCooldown returns an error carrying the time to wait if a code for purpose was sent to phoneNumber
+// within the resend cooldown, and starts a new cooldown otherwise. Callers take it before looking up the user,
+// so registered and unknown numbers are throttled alike and cannot be told apart.
+func (s *service) startCodeCooldown(purpose, phoneNumber string) error {
+	key := purpose + ":" + phoneNumber
+
+	s.otpMu.Lock()
+	defer s.otpMu.Unlock()
+
+	var cooldownUntil int64
+	if err := s.getCacheJSON(otpCooldownKeyPrefix+key, &cooldownUntil); err == nil {
+		if wait := time.Until(time.Unix(cooldownUntil, 0)); wait > 0 {
+			return apperror.TooManyRequestsError("a code was sent recently, wait before requesting a new one", wait)
+		}
+	}
+
+	cooldownUntil = time.Now().Add(config.GetConfig().OTP.ResendCooldown).Unix()
+	if err := s.setCacheJSON(otpCooldownKeyPrefix+key, cooldownUntil, expireInUntil(cooldownUntil)); err != nil {
+		return fmt.Errorf("failed to store code resend cooldown. error: %w", err)
+	}
+
+	return nil
+}
+
+// sendCode generates a code for purpose valid for ttl, replacing any previous one, and sends it to phoneNumber.
+// text is a format string with a single %s verb for the code.
+// The caller has started the resend cooldown with startCodeCooldown, it is lifted again if sending fails.
+func (s *service) sendCode(ctx context.Context, purpose, phoneNumber, text string, ttl time.Duration) error {
+	key := purpose + ":" + phoneNumber
+
+	code, err := generateCode()
+	if err != nil {
+		return err
+	}
+
+	otp := oneTimeCode{
+		Hash:      hashCode(key, code),
+		ExpiresAt: time.Now().Add(ttl).Unix(),
+	}
+	s.otpMu.Lock()
+	err = s.setCacheJSON(otpKeyPrefix+key, otp, expireInUntil(otp.ExpiresAt))
+	s.otpMu.Unlock()
+	if err != nil {
+		return fmt.Errorf("failed to store code. error: %w", err)
+	}
+
+	// Sending is a network call, it must not hold otpMu.
+	if err = s.sms.Send(ctx, phoneNumber, fmt.Sprintf(text, code)); err != nil {
+		s.otpMu.Lock()
+		s.rtCache.Del([]byte(otpKeyPrefix + key))
+		s.rtCache.Del([]byte(otpCooldownKeyPrefix + key))
+		s.otpMu.Unlock()
+		return fmt.Errorf("failed to send code. error: %w", err)
+	}
+
+	return nil
+}
+
+// checkCode consumes the code sent to phoneNumber for purpose if it matches.
+// The code is discarded after too many wrong attempts.
+func (s *service) checkCode(purpose, phoneNumber, code string) error {
+	key := purpose + ":" + phoneNumber
+
+	s.otpMu.Lock()
+	defer s.otpMu.Unlock()
+
+	var otp oneTimeCode
+	if err := s.getCacheJSON(otpKeyPrefix+key, &otp); err != nil {
+		return errInvalidCode
+	}
+	if time.Now().Unix() >= otp.ExpiresAt {
+		s.rtCache.Del([]byte(otpKeyPrefix + key))
+		return errInvalidCode
+	}
+
+	if subtle.ConstantTimeCompare([]byte(hashCode(key, code)), []byte(otp.Hash)) == 1 {
+		s.rtCache.Del([]byte(otpKeyPrefix + key))
+		return nil
+	}
+
+	otp.Attempts++
+	if otp.Attempts >= config.GetConfig().OTP.MaxAttempts {
+		s.logger.
+			WithField("event", "otp_attempts_exceeded").
+			WithField("purpose", purpose).
+			Warn("security event: too many wrong codes, discarding code")
+		s.rtCache.Del([]byte(otpKeyPrefix + key))
+		return apperror.TooManyRequestsError("too many wrong codes, request a new one", 0)
+	}
+	if err := s.setCacheJSON(otpKeyPrefix+key, otp, expireInUntil(otp.ExpiresAt)); err != nil {
+		return fmt.Errorf("failed to store code attempts. error: %w", err)
+	}
+
+	return errInvalidCode
+}
+
+// generateCode returns a uniformly random code of otpDigits decimal digits.
+func generateCode() (string, error) {
+	max := big.NewInt(1)
+	for i := 0; i < otpDigits; i++ {
+		max.Mul(max, big.NewInt(10))
+	}
+
+	n, err := rand.Int(rand.Reader, max)
+	if err != nil {
+		return "", fmt.Errorf("failed to generate code. error: %w", err)
+	}
+
+	return fmt.Sprintf("%0*d", otpDigits, n), nil
+}
+
+// hashCode binds code to the purpose and phone number it was sent for.
+func hashCode(key, code string) string {
+	sum := sha256.Sum256([]byte(key + ":" + code))
+	return hex.EncodeToString(sum[:])
+}
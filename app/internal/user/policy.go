@@ -0,0 +1,67 @@
+package user
+
+import (
+	"net/http"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/senizdegen/sdu-housing/user-service/internal/apperror"
+)
+
+// Policy declares who may call a route. The zero Policy allows every authenticated user.
+type Policy struct {
+	// Roles may call the route for any user.
+	Roles []string
+	// Owner lets users call the route for their own :uuid.
+	Owner bool
+}
+
+var (
+	anyUser      = Policy{}
+	ownerOnly    = Policy{Owner: true}
+	ownerOrAdmin = Policy{Roles: []string{RoleAdmin}, Owner: true}
+	adminOnly    = Policy{Roles: []string{RoleAdmin}}
+	staffOrAdmin = Policy{Roles: []string{RoleAdmin, RoleDormitoryStaff}}
+)
+
+// Allows reports whether the holder of claims may act on the user ownerUUID.
+func (p Policy) Allows(claims UserClaims, ownerUUID string) bool {
+	if len(p.Roles) == 0 && !p.Owner {
+		return true
+	}
+	if p.Owner && ownerUUID != "" && claims.UUID == ownerUUID {
+		return true
+	}
+
+	role := effectiveRole(claims.Role)
+	for _, allowed := range p.Roles {
+		if role == allowed {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Authorize returns a middleware enforcing p on authenticated requests, see Authenticate.
+// The owner of the request is the :uuid route parameter.
+func Authorize(p Policy) func(next apperror.AppHandler) apperror.AppHandler {
+	return func(next apperror.AppHandler) apperror.AppHandler {
+		return func(w http.ResponseWriter, r *http.Request) error {
+			claims, ok := ClaimsFromContext(r.Context())
+			if !ok {
+				return apperror.UnauthorizedError("authentication is required")
+			}
+
+			var ownerUUID string
+			if params, ok := r.Context().Value(httprouter.ParamsKey).(httprouter.Params); ok {
+				ownerUUID = params.ByName("uuid")
+			}
+
+			if !p.Allows(claims, ownerUUID) {
+				return apperror.ForbiddenError("access denied")
+			}
+
+			return next(w, r)
+		}
+	}
+}
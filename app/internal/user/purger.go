@@ -0,0 +1,29 @@
+package user
+
+import (
+	"context"
+	"time"
+
+	"github.com/senizdegen/sdu-housing/user-service/pkg/logging"
+)
+
+// RunPurger purges deleted users whose grace period is over every interval until ctx is done.
+func RunPurger(ctx context.Context, s Service, interval time.Duration, logger logging.Logger) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		purged, err := s.PurgeDeleted(ctx)
+		if err != nil {
+			logger.Error(err)
+		} else if purged > 0 {
+			logger.Infof("purged %d deleted users", purged)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
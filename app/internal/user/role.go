@@ -0,0 +1,36 @@
+package user
+
+const (
+	RoleStudent        = "student"
+	RoleLandlord       = "landlord"
+	RoleDormitoryStaff = "dormitory_staff"
+	RoleAdmin          = "admin"
+)
+
+// DefaultRole is assigned on registration. Users stored before roles existed have it implicitly.
+const DefaultRole = RoleStudent
+
+// effectiveRole maps the empty role of legacy users and tokens to DefaultRole.
+func effectiveRole(role string) string {
+	if role == "" {
+		return DefaultRole
+	}
+	return role
+}
+
+func isRole(role string) bool {
+	switch role {
+	case RoleStudent, RoleLandlord, RoleDormitoryStaff, RoleAdmin:
+		return true
+	}
+	return false
+}
+
+// canEnrollMFA reports whether users with role may enable two-factor authentication.
+func canEnrollMFA(role string) bool {
+	switch effectiveRole(role) {
+	case RoleLandlord, RoleAdmin:
+		return true
+	}
+	return false
+}
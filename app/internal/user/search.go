@@ -0,0 +1,165 @@
+package user
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/senizdegen/sdu-housing/user-service/internal/apperror"
+	"github.com/senizdegen/sdu-housing/user-service/pkg/phone"
+)
+
+const (
+	SearchFieldFullName    = "full_name"
+	SearchFieldPhoneNumber = "phone_number"
+
+	minSearchLength = 2
+)
+
+// Searcher finds users by partial name or phone number prefix.
+// It only depends on the user model, so every Storage implementation can provide it.
+type Searcher interface {
+	// Search returns up to query.Limit not deleted users matching query.Text,
+	// best matches first, skipping the first query.Offset matches.
+	Search(ctx context.Context, query SearchQuery) ([]SearchHit, error)
+}
+
+type SearchQuery struct {
+	Text string
+	// Field is SearchFieldPhoneNumber for phone number prefixes and SearchFieldFullName otherwise.
+	Field  string
+	Offset int
+	Limit  int
+}
+
+// NewSearchQuery searches phone numbers when text only has phone number characters and names otherwise.
+// Phone number prefixes are normalized like stored numbers, so "8701" finds "+7701...".
+func NewSearchQuery(text string, offset, limit int) (SearchQuery, error) {
+	query := SearchQuery{Text: strings.TrimSpace(text), Field: SearchFieldFullName, Offset: offset, Limit: limit}
+
+	if utf8.RuneCountInString(query.Text) < minSearchLength {
+		return query, apperror.BadRequestError(fmt.Sprintf("q must be at least %d characters long", minSearchLength))
+	}
+	if !isPhoneQuery(query.Text) && len(NameWords(query.Text)) == 0 {
+		return query, apperror.BadRequestError("q must contain letters or digits")
+	}
+	if isPhoneQuery(query.Text) {
+		digits, ok := phone.NormalizePrefix(query.Text, phone.DefaultRegion)
+		if !ok {
+			return query, apperror.BadRequestError("q is not a valid phone number prefix")
+		}
+		query.Field = SearchFieldPhoneNumber
+		query.Text = digits
+	}
+
+	switch {
+	case query.Limit == 0:
+		query.Limit = defaultListLimit
+	case query.Limit < 0 || query.Limit > maxListLimit:
+		return query, apperror.BadRequestError(fmt.Sprintf("limit must be between 1 and %d", maxListLimit))
+	}
+	if query.Offset < 0 {
+		return query, apperror.BadRequestError("offset must be a non-negative integer")
+	}
+
+	return query, nil
+}
+
+type SearchHit struct {
+	User User `json:"user"`
+	// Score ranks name matches: every query word scores 2 if it is a whole word of the name and 1 if it
+	// only starts one, plus 1/(k+2) for the k-th word of the name, and the words are averaged.
+	// Equal scores are ordered by name. Phone number matches all score 1 and are ordered by number.
+	Score     float64   `json:"score"`
+	Highlight Highlight `json:"highlight"`
+}
+
+// Highlight points at the parts of Field that matched, as rune offsets into Value.
+type Highlight struct {
+	Field   string  `json:"field"`
+	Value   string  `json:"value"`
+	Matches []Match `json:"matches"`
+}
+
+type Match struct {
+	Start int `json:"start"`
+	End   int `json:"end"`
+}
+
+type SearchResult struct {
+	Hits []SearchHit `json:"hits"`
+	// NextOffset is the offset of the next page, zero on the last page.
+	NextOffset int `json:"next_offset,omitempty"`
+}
+
+func isPhoneQuery(text string) bool {
+	digits := 0
+	for _, r := range text {
+		switch {
+		case unicode.IsDigit(r):
+			digits++
+		case r == '+' || r == ' ' || r == '-' || r == '(' || r == ')':
+		default:
+			return false
+		}
+	}
+	return digits > 0
+}
+
+// NameWords returns the lowercased words of name. Names match a query when every word of the query
+// is the prefix of one of their words.
+func NameWords(name string) []string {
+	return strings.FieldsFunc(strings.ToLower(name), func(r rune) bool { return !isNameRune(r) })
+}
+
+func isNameRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+// highlight marks the parts of the searched field of u that match query.
+// Names match at word starts, phone numbers at the start of their digits.
+func highlight(u User, query SearchQuery) Highlight {
+	if query.Field == SearchFieldPhoneNumber {
+		h := Highlight{Field: SearchFieldPhoneNumber, Value: u.PhoneNumber, Matches: []Match{}}
+		runes := []rune(u.PhoneNumber)
+		start, matched := -1, 0
+		for i, r := range runes {
+			if matched == len(query.Text) {
+				break
+			}
+			if !unicode.IsDigit(r) {
+				continue
+			}
+			if r != rune(query.Text[matched]) {
+				return h
+			}
+			if start < 0 {
+				start = i
+			}
+			matched++
+			if matched == len(query.Text) {
+				h.Matches = append(h.Matches, Match{Start: start, End: i + 1})
+			}
+		}
+		return h
+	}
+
+	// the same words NameWords stores, so every highlighted part is one the storage matched
+	h := Highlight{Field: SearchFieldFullName, Value: u.FullName, Matches: []Match{}}
+	name := []rune(strings.ToLower(u.FullName))
+	terms := NameWords(query.Text)
+	for i := range name {
+		if !isNameRune(name[i]) || (i > 0 && isNameRune(name[i-1])) {
+			continue
+		}
+		for _, term := range terms {
+			termRunes := []rune(term)
+			if i+len(termRunes) <= len(name) && string(name[i:i+len(termRunes)]) == term {
+				h.Matches = append(h.Matches, Match{Start: i, End: i + len(termRunes)})
+			}
+		}
+	}
+	return h
+}
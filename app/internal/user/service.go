@@ -5,15 +5,16 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"sync"
 	"time"
 
 	"github.com/cristalhq/jwt/v3"
-	"github.com/google/uuid"
 	"github.com/senizdegen/sdu-housing/user-service/internal/apperror"
 	"github.com/senizdegen/sdu-housing/user-service/internal/config"
+	"github.com/senizdegen/sdu-housing/user-service/internal/password"
 	"github.com/senizdegen/sdu-housing/user-service/pkg/cache"
+	"github.com/senizdegen/sdu-housing/user-service/pkg/keyring"
 	"github.com/senizdegen/sdu-housing/user-service/pkg/logging"
-	"golang.org/x/crypto/bcrypt"
 )
 
 var _ Service = &service{}
@@ -22,19 +23,52 @@ type service struct {
 	storage Storage
 	logger  logging.Logger
 	rtCache cache.Repository
+	// lockoutCache holds the failed login counters.
+	lockoutCache cache.Repository
+	keys         *keyring.Keyring
+	sms          SMSSender
+	// passwords is the policy new passwords must satisfy.
+	passwords *password.Policy
+	hasher    PasswordHasher
+	// families serializes refresh token rotation per token family so a token can be consumed only once.
+	families keyedMutex
+	// otpMu serializes one-time code checks so attempts are counted exactly.
+	otpMu sync.Mutex
+	// lockoutMu serializes failed login counting.
+	lockoutMu sync.Mutex
+	// challenges serializes attempts per MFA challenge so its wrong codes are counted exactly.
+	challenges keyedMutex
 }
 
-func NewService(userStorage Storage, logger logging.Logger, rtCache cache.Repository) (Service, error) {
+func NewService(userStorage Storage, logger logging.Logger, rtCache, lockoutCache cache.Repository, keys *keyring.Keyring, sms SMSSender, passwords *password.Policy, hasher PasswordHasher) (Service, error) {
 	return &service{
-		storage: userStorage,
-		logger:  logger,
-		rtCache: rtCache,
+		storage:      userStorage,
+		logger:       logger,
+		rtCache:      rtCache,
+		lockoutCache: lockoutCache,
+		keys:         keys,
+		sms:          sms,
+		passwords:    passwords,
+		hasher:       hasher,
 	}, nil
 }
 
+const (
+	verifyPhoneText   = "SDU Housing verification code: %s"
+	resetPasswordText = "SDU Housing password reset code: %s. If you did not request it, ignore this message"
+)
+
 type RT struct {
 	RefreshToken string `json:"refresh_token"`
 }
+
+type TokenPair struct {
+	Token        string `json:"token"`
+	RefreshToken string `json:"refresh_token"`
+	// ExpiresIn is the access token lifetime in seconds.
+	ExpiresIn int64 `json:"expires_in"`
+}
+
 type UserClaims struct {
 	jwt.RegisteredClaims
 	UUID string `json:"uuid"`
@@ -43,10 +77,29 @@ type UserClaims struct {
 
 type Service interface {
 	GetOne(ctx context.Context, uuid string) (User, error)
-	GetByPhoneNumberAndPassword(ctx context.Context, email, password string) (User, error)
+	Find(ctx context.Context, filter UserFilter) (UserPage, error)
+	Search(ctx context.Context, query SearchQuery) (SearchResult, error)
+	GetByPhoneNumberAndPassword(ctx context.Context, phoneNumber, password, clientIP string) (User, error)
+	Login(ctx context.Context, dto LoginDTO) (LoginResponse, error)
+	LoginMFA(ctx context.Context, dto MFALoginDTO) (LoginResponse, error)
+	EnrollTOTP(ctx context.Context, uuid string) (TOTPEnrollment, error)
+	ConfirmTOTP(ctx context.Context, uuid string, dto ConfirmTOTPDTO) (RecoveryCodes, error)
 	Create(ctx context.Context, dto CreateUserDTO) (User, error)
+	VerifyPhone(ctx context.Context, dto VerifyPhoneDTO) (LoginResponse, error)
+	ResendVerificationCode(ctx context.Context, dto SendCodeDTO) error
+	ForgotPassword(ctx context.Context, dto SendCodeDTO) error
+	ResetPassword(ctx context.Context, dto ResetPasswordDTO) error
+	ChangePassword(ctx context.Context, uuid string, dto ChangePasswordDTO) (TokenPair, error)
+	Update(ctx context.Context, uuid string, dto UpdateUserDTO, ifMatch string) (User, error)
+	Delete(ctx context.Context, uuid string) error
+	Restore(ctx context.Context, uuid string) (User, error)
+	PurgeDeleted(ctx context.Context) (int64, error)
 	GenerateAccessToken(u User) ([]byte, error)
-	UpdateRefreshToken(rt RT) ([]byte, error)
+	UpdateRefreshToken(ctx context.Context, rt RT) ([]byte, error)
+	VerifyAccessToken(token string) (UserClaims, error)
+	Logout(claims UserClaims, rt RT) error
+	LogoutAll(claims UserClaims) error
+	ClearLockout(ctx context.Context, phoneNumber, clientIP string) error
 }
 
 func (s *service) GetOne(ctx context.Context, uuid string) (User, error) {
@@ -62,21 +115,61 @@ func (s *service) GetOne(ctx context.Context, uuid string) (User, error) {
 	return u, nil
 }
 
-func (s service) GetByPhoneNumberAndPassword(ctx context.Context, phoneNumber, password string) (u User, err error) {
-	u, err = s.storage.FindByPhoneNumber(ctx, phoneNumber)
+func (s *service) Find(ctx context.Context, filter UserFilter) (page UserPage, err error) {
+	if err = filter.Validate(); err != nil {
+		return page, err
+	}
 
-	if err != nil {
+	// One extra user tells whether there is a next page.
+	limit := filter.Limit
+	filter.Limit++
 
+	users, err := s.storage.Find(ctx, filter)
+	if err != nil {
 		if errors.Is(err, apperror.ErrNotFound) {
-
-			return u, err
+			return page, err
 		}
-		return u, fmt.Errorf("failed to find user by phone number. error: %w", err)
+		return page, fmt.Errorf("failed to find users. error: %w", err)
 	}
 
-	//passwords in db are hashed
-	if err = bcrypt.CompareHashAndPassword([]byte(u.Password), []byte(password)); err != nil {
-		return u, apperror.ErrNotFound
+	if len(users) > limit {
+		users = users[:limit]
+		page.NextCursor = users[limit-1].UUID
+	}
+	page.Users = users
+
+	return page, nil
+}
+
+func (s *service) Search(ctx context.Context, query SearchQuery) (result SearchResult, err error) {
+	// One extra hit tells whether there is a next page.
+	limit := query.Limit
+	query.Limit++
+
+	hits, err := s.storage.Search(ctx, query)
+	if err != nil {
+		return result, fmt.Errorf("failed to search users. error: %w", err)
+	}
+
+	if len(hits) > limit {
+		hits = hits[:limit]
+		result.NextOffset = query.Offset + limit
+	}
+	for i := range hits {
+		hits[i].Highlight = highlight(hits[i].User, query)
+	}
+	result.Hits = hits
+
+	return result, nil
+}
+
+func (s *service) GetByPhoneNumberAndPassword(ctx context.Context, phoneNumber, password, clientIP string) (u User, err error) {
+	u, err = s.authenticate(ctx, phoneNumber, password, clientIP)
+	if err != nil {
+		return u, err
+	}
+	if u.TOTPEnabled {
+		return User{}, apperror.ForbiddenError("two-factor authentication is required, log in with POST " + authLoginURL)
 	}
 
 	s.logger.Info("Generate jwt token")
@@ -90,16 +183,139 @@ func (s service) GetByPhoneNumberAndPassword(ctx context.Context, phoneNumber, p
 	return u, nil
 }
 
+func (s *service) Login(ctx context.Context, dto LoginDTO) (resp LoginResponse, err error) {
+	u, err := s.authenticate(ctx, dto.PhoneNumber, dto.Password, dto.ClientIP)
+	if err != nil {
+		if errors.Is(err, apperror.ErrNotFound) {
+			return resp, apperror.UnauthorizedError("invalid phone number or password")
+		}
+		return resp, err
+	}
+
+	if u.TOTPEnabled {
+		s.logger.Info("start mfa challenge")
+		mfaToken, err := s.startMFAChallenge(u)
+		if err != nil {
+			return resp, err
+		}
+		return LoginResponse{MFAToken: mfaToken}, nil
+	}
+
+	s.logger.Info("Generate jwt token")
+	pair, err := s.issueTokenPair(u)
+	if err != nil {
+		return resp, fmt.Errorf("failed to generate token. error: %w", err)
+	}
+
+	return LoginResponse{TokenPair: &pair, User: &u}, nil
+}
+
+// authenticate returns the user registered with phoneNumber if password matches its hash.
+// Unknown phone numbers and wrong passwords are both reported as apperror.ErrNotFound
+// and counted as failed logins of phoneNumber from clientIP.
+func (s *service) authenticate(ctx context.Context, phoneNumber, password, clientIP string) (u User, err error) {
+	normalized, err := normalizePhoneNumber(phoneNumber)
+	if err != nil {
+		return u, err
+	}
+	if err = s.startLoginAttempt(normalized, clientIP); err != nil {
+		return u, err
+	}
+
+	u, err = s.findByPhoneNumber(ctx, phoneNumber)
+
+	if err != nil {
+
+		if errors.Is(err, apperror.ErrNotFound) {
+			s.loginAttemptFailed(normalized, clientIP)
+			return u, err
+		}
+		s.loginAttemptSucceeded(normalized, clientIP, false)
+		return u, fmt.Errorf("failed to find user by phone number. error: %w", err)
+	}
+
+	//passwords in db are hashed
+	if err = u.CheckPassword(s.hasher, password); err != nil {
+		if !errors.Is(err, errPasswordMismatch) {
+			s.logger.Errorf("failed to check password of user %s. error: %s", u.UUID, err)
+		}
+		s.loginAttemptFailed(normalized, clientIP)
+		return User{}, apperror.ErrNotFound
+	}
+	// Users with two-factor authentication keep their failures until the second step succeeds,
+	// a known password alone must not reset the lockout of guessing codes.
+	s.loginAttemptSucceeded(normalized, clientIP, !u.TOTPEnabled)
+
+	if !u.PhoneVerified() {
+		return User{}, ErrPhoneNotVerified
+	}
+
+	if s.hasher.NeedsRehash(u.Password) {
+		s.rehashPassword(ctx, &u, password)
+	}
+
+	return u, nil
+}
+
+// rehashPassword upgrades the stored hash of u to the preferred algorithm and its current settings.
+// Failures are only logged, the user is logged in either way and the upgrade is retried on the next login.
+func (s *service) rehashPassword(ctx context.Context, u *User, password string) {
+	upgraded := *u
+	upgraded.Password = password
+	if err := upgraded.GeneratePasswordHash(s.hasher); err != nil {
+		s.logger.Errorf("failed to rehash password of user %s. error: %s", u.UUID, err)
+		return
+	}
+
+	if err := s.storage.Update(ctx, upgraded); err != nil {
+		s.logger.Warnf("failed to store rehashed password of user %s. error: %s", u.UUID, err)
+		return
+	}
+	upgraded.Version++
+
+	s.logger.Infof("password hash of user %s upgraded", u.UUID)
+	*u = upgraded
+}
+
+// findByPhoneNumber looks the user up by the E.164 form of phoneNumber.
+// Users registered before normalization are still found by the number as they typed it.
+func (s *service) findByPhoneNumber(ctx context.Context, phoneNumber string) (u User, err error) {
+	normalized, err := normalizePhoneNumber(phoneNumber)
+	if err != nil {
+		return u, err
+	}
+
+	u, err = s.storage.FindByPhoneNumber(ctx, normalized)
+	if errors.Is(err, apperror.ErrNotFound) && normalized != phoneNumber {
+		return s.storage.FindByPhoneNumber(ctx, phoneNumber)
+	}
+	return u, err
+}
+
 func (s *service) Create(ctx context.Context, dto CreateUserDTO) (u User, err error) {
-	s.logger.Debug("check password and repeated password")
-	if dto.Password != dto.RepeatPassword {
-		return u, apperror.BadRequestError("password does not match repeated password")
+	s.logger.Debug("normalize phone number")
+	if dto.PhoneNumber, err = normalizePhoneNumber(dto.PhoneNumber); err != nil {
+		return u, err
+	}
+
+	s.logger.Debug("check password against the password policy")
+	if err = s.validateNewPassword(dto.Password, dto.RepeatPassword, dto.PhoneNumber); err != nil {
+		return u, err
+	}
+
+	s.logger.Debug("check phone number is not registered")
+	_, err = s.storage.FindByPhoneNumber(ctx, dto.PhoneNumber)
+	if err == nil {
+		return u, ErrPhoneNumberTaken
+	}
+	if !errors.Is(err, apperror.ErrNotFound) {
+		return u, fmt.Errorf("failed to create user. error: %w", err)
 	}
 
 	user := NewUser(dto)
 
 	s.logger.Debug("generate password hash")
-	err = user.GeneratePasswordHash()
+	err = user.GeneratePasswordHash(s.hasher)
 	if err != nil {
 		s.logger.Errorf("failed to create user due to error: %s", err)
 		return
@@ -111,7 +327,7 @@ func (s *service) Create(ctx context.Context, dto CreateUserDTO) (u User, err er
 		if errors.Is(err, apperror.ErrNotFound) {
 			return u, err
 		}
-		return u, fmt.Errorf("failed to create user. error: %s", err)
+		return u, fmt.Errorf("failed to create user. error: %w", err)
 	}
 
 	u, err = s.GetOne(ctx, userUUID)
@@ -119,70 +335,302 @@ func (s *service) Create(ctx context.Context, dto CreateUserDTO) (u User, err er
 		return u, fmt.Errorf("failed to create user. error: %s", err)
 	}
 
-	s.logger.Info("Generate jwt token")
-	tokenBytes, err := s.GenerateAccessToken(u)
-	if err != nil {
-		return u, fmt.Errorf("failed to generate token. error: %s", err)
+	// The account is created anyway, the user can ask for the code again.
+	// The first code is sent even within a cooldown started by resend requests before registration.
+	s.logger.Info("send phone verification code")
+	if err = s.startCodeCooldown(otpPurposeVerifyPhone, u.PhoneNumber); err != nil {
+		s.logger.Debugf("phone verification code requested recently. error: %s", err)
+	}
+	if err = s.sendCode(ctx, otpPurposeVerifyPhone, u.PhoneNumber, verifyPhoneText, config.GetConfig().OTP.TTL); err != nil {
+		s.logger.Errorf("failed to send phone verification code. error: %s", err)
 	}
 
-	u.JWTToken = string(tokenBytes)
 	return u, nil
 }
 
-func (s *service) GenerateAccessToken(u User) ([]byte, error) {
-	key := []byte(config.GetConfig().JWT.Secret)
-	signer, err := jwt.NewSignerHS(jwt.HS256, key)
+// VerifyPhone activates the user registered with dto.PhoneNumber and logs it in.
+func (s *service) VerifyPhone(ctx context.Context, dto VerifyPhoneDTO) (resp LoginResponse, err error) {
+	if dto.PhoneNumber, err = normalizePhoneNumber(dto.PhoneNumber); err != nil {
+		return resp, err
+	}
+
+	u, err := s.storage.FindByPhoneNumber(ctx, dto.PhoneNumber)
 	if err != nil {
-		return nil, err
+		if errors.Is(err, apperror.ErrNotFound) {
+			return resp, errInvalidCode
+		}
+		return resp, fmt.Errorf("failed to find user by phone number. error: %w", err)
 	}
-	builder := jwt.NewBuilder(signer)
-	claims := UserClaims{
-		RegisteredClaims: jwt.RegisteredClaims{
-			ID:        u.UUID,
-			Audience:  []string{"users"},
-			ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Minute * 60)),
-		},
-		UUID: u.UUID,
-		Role: u.Role,
+	// reported like a wrong code, so verified numbers cannot be told apart
+	if u.PhoneVerified() {
+		return resp, errInvalidCode
 	}
 
-	token, err := builder.Build(claims)
+	if err = s.checkCode(otpPurposeVerifyPhone, u.PhoneNumber, dto.Code); err != nil {
+		return resp, err
+	}
+
+	u.Status = StatusActive
+	u.UpdatedAt = time.Now().Unix()
+	if err = s.storage.Update(ctx, u); err != nil {
+		return resp, fmt.Errorf("failed to activate user. error: %w", err)
+	}
+	u.Version++
+	s.logger.Infof("user %s verified phone number", u.UUID)
+
+	pair, err := s.issueTokenPair(u)
 	if err != nil {
-		return nil, err
+		return resp, fmt.Errorf("failed to generate token. error: %w", err)
 	}
 
-	s.logger.Info("create refresh token")
-	refreshTokenUuid := uuid.New()
-	userBytes, _ := json.Marshal(u)
-	err = s.rtCache.Set([]byte(refreshTokenUuid.String()), userBytes, 0)
+	return LoginResponse{TokenPair: &pair, User: &u}, nil
+}
+
+// ResendVerificationCode sends a new code to an unverified phone number.
+// Unknown and already verified numbers are silently ignored, and share the resend cooldown,
+// so they cannot be told apart.
+func (s *service) ResendVerificationCode(ctx context.Context, dto SendCodeDTO) (err error) {
+	if dto.PhoneNumber, err = normalizePhoneNumber(dto.PhoneNumber); err != nil {
+		return err
+	}
+	if err = s.startCodeCooldown(otpPurposeVerifyPhone, dto.PhoneNumber); err != nil {
+		return err
+	}
+
+	u, err := s.storage.FindByPhoneNumber(ctx, dto.PhoneNumber)
 	if err != nil {
-		s.logger.Error(err)
-		return nil, err
+		if errors.Is(err, apperror.ErrNotFound) {
+			return nil
+		}
+		return fmt.Errorf("failed to find user by phone number. error: %w", err)
+	}
+	if u.PhoneVerified() {
+		return nil
 	}
 
-	jsonBytes, err := json.Marshal(map[string]string{
-		"token":         token.String(),
-		"refresh_token": refreshTokenUuid.String(),
-	})
+	return s.sendCode(ctx, otpPurposeVerifyPhone, u.PhoneNumber, verifyPhoneText, config.GetConfig().OTP.TTL)
+}
 
+// ForgotPassword sends a password reset code to the registered phone number.
+// Unknown numbers are silently ignored, and share the resend cooldown, so they cannot be told apart.
+func (s *service) ForgotPassword(ctx context.Context, dto SendCodeDTO) error {
+	normalized, err := normalizePhoneNumber(dto.PhoneNumber)
 	if err != nil {
-		return nil, err
+		return err
+	}
+	if err = s.startCodeCooldown(otpPurposeResetPassword, normalized); err != nil {
+		return err
+	}
+
+	u, err := s.findByPhoneNumber(ctx, dto.PhoneNumber)
+	if err != nil {
+		if errors.Is(err, apperror.ErrNotFound) {
+			return nil
+		}
+		return fmt.Errorf("failed to find user by phone number. error: %w", err)
+	}
+
+	return s.sendCode(ctx, otpPurposeResetPassword, u.PhoneNumber, resetPasswordText, config.GetConfig().OTP.PasswordResetTTL)
+}
+
+// ResetPassword sets a new password if dto.Code is the reset code sent to the user
+// and ends all of its sessions.
+func (s *service) ResetPassword(ctx context.Context, dto ResetPasswordDTO) (err error) {
+	u, err := s.findByPhoneNumber(ctx, dto.PhoneNumber)
+	if err != nil {
+		if errors.Is(err, apperror.ErrNotFound) {
+			return errInvalidCode
+		}
+		return fmt.Errorf("failed to find user by phone number. error: %w", err)
+	}
+
+	// Checked before the code so a rejected password does not use it up.
+	if err = s.validateNewPassword(dto.Password, dto.RepeatPassword, u.PhoneNumber); err != nil {
+		return err
+	}
+
+	if err = s.checkCode(otpPurposeResetPassword, u.PhoneNumber, dto.Code); err != nil {
+		return err
+	}
+
+	u.Password = dto.Password
+	if err = u.GeneratePasswordHash(s.hasher); err != nil {
+		return err
+	}
+	// The code was delivered to the phone, which proves the number as well.
+	if !u.PhoneVerified() {
+		u.Status = StatusActive
+	}
+	now := time.Now()
+	u.UpdatedAt = now.Unix()
+	u.PasswordChangedAt = now.Unix()
+
+	if err = s.storage.Update(ctx, u); err != nil {
+		return fmt.Errorf("failed to reset password. error: %w", err)
+	}
+
+	s.logger.Infof("user %s reset password, revoke all tokens", u.UUID)
+	return s.revokeUserTokens(u.UUID, now)
+}
+
+// Update applies dto to the user. A non-empty ifMatch must match the current ETag of the user.
+func (s *service) Update(ctx context.Context, uuid string, dto UpdateUserDTO, ifMatch string) (u User, err error) {
+	if err = dto.Validate(); err != nil {
+		return u, err
+	}
+
+	u, err = s.GetOne(ctx, uuid)
+	if err != nil {
+		return u, err
+	}
+	if ifMatch != "" && !etagMatches(ifMatch, u.ETag()) {
+		return u, apperror.ErrPreconditionFailed
+	}
+
+	dto.Apply(&u)
+	u.UpdatedAt = time.Now().Unix()
+
+	if err = s.storage.Update(ctx, u); err != nil {
+		if errors.Is(err, apperror.ErrNotFound) || errors.Is(err, apperror.ErrPreconditionFailed) {
+			return u, err
+		}
+		return u, fmt.Errorf("failed to update user. error: %w", err)
 	}
+	u.Version++
 
-	return jsonBytes, nil
+	return u, nil
 }
 
-func (s *service) UpdateRefreshToken(rt RT) ([]byte, error) {
-	defer s.rtCache.Del([]byte(rt.RefreshToken))
+// Delete soft deletes the user and ends all of its sessions.
+// The account can be restored until the deletion grace period is over.
+func (s *service) Delete(ctx context.Context, uuid string) error {
+	now := time.Now()
+	if err := s.storage.Delete(ctx, uuid, now.Unix()); err != nil {
+		if errors.Is(err, apperror.ErrNotFound) {
+			return err
+		}
+		return fmt.Errorf("failed to delete user. error: %w", err)
+	}
+
+	s.logger.Infof("user %s deleted, revoke all tokens", uuid)
+	return s.revokeUserTokens(uuid, now)
+}
 
-	userBytes, err := s.rtCache.Get([]byte(rt.RefreshToken))
+func (s *service) Restore(ctx context.Context, uuid string) (u User, err error) {
+	deletedAfter := time.Now().Add(-config.GetConfig().Deletion.GracePeriod).Unix()
+	if err = s.storage.Restore(ctx, uuid, deletedAfter); err != nil {
+		if errors.Is(err, apperror.ErrNotFound) {
+			return u, err
+		}
+		return u, fmt.Errorf("failed to restore user. error: %w", err)
+	}
+
+	s.logger.Infof("user %s restored", uuid)
+	return s.GetOne(ctx, uuid)
+}
+
+// PurgeDeleted removes or anonymizes users whose deletion grace period is over.
+func (s *service) PurgeDeleted(ctx context.Context) (int64, error) {
+	cfg := config.GetConfig().Deletion
+	deletedBefore := time.Now().Add(-cfg.GracePeriod).Unix()
+
+	purged, err := s.storage.Purge(ctx, deletedBefore, cfg.Anonymize)
+	if err != nil {
+		return 0, fmt.Errorf("failed to purge deleted users. error: %w", err)
+	}
+
+	return purged, nil
+}
+
+// ChangePassword replaces the password of the user after checking the current one.
+// Every token issued before the change is revoked, the returned pair keeps the caller logged in.
+func (s *service) ChangePassword(ctx context.Context, uuid string, dto ChangePasswordDTO) (pair TokenPair, err error) {
+	u, err := s.GetOne(ctx, uuid)
+	if err != nil {
+		return pair, err
+	}
+	// Wrong current passwords count as failed logins, a stolen access token must not allow guessing it.
+	phoneNumber := lockoutPhoneNumber(u)
+	if err = s.startLoginAttempt(phoneNumber, dto.ClientIP); err != nil {
+		return pair, err
+	}
+	if err = u.CheckPassword(s.hasher, dto.CurrentPassword); err != nil {
+		s.loginAttemptFailed(phoneNumber, dto.ClientIP)
+		return pair, apperror.ValidationError(map[string][]string{
+			"current_password": {"current password is incorrect"},
+		})
+	}
+	s.loginAttemptSucceeded(phoneNumber, dto.ClientIP, !u.TOTPEnabled)
+	if err = s.validateNewPassword(dto.Password, dto.RepeatPassword, u.PhoneNumber); err != nil {
+		return pair, err
+	}
+	if err = u.CheckPassword(s.hasher, dto.Password); err == nil {
+		return pair, apperror.ValidationError(map[string][]string{
+			"password": {"must differ from the current password"},
+		})
+	}
+
+	u.Password = dto.Password
+	if err = u.GeneratePasswordHash(s.hasher); err != nil {
+		return pair, err
+	}
+	now := time.Now()
+	u.UpdatedAt = now.Unix()
+	u.PasswordChangedAt = now.Unix()
+
+	if err = s.storage.Update(ctx, u); err != nil {
+		if errors.Is(err, apperror.ErrNotFound) || errors.Is(err, apperror.ErrPreconditionFailed) {
+			return pair, err
+		}
+		return pair, fmt.Errorf("failed to change password. error: %w", err)
+	}
+	u.Version++
+
+	s.logger.Infof("user %s changed password, revoke all tokens", u.UUID)
+	if err = s.revokeUserTokens(u.UUID, now); err != nil {
+		return pair, err
+	}
+
+	return s.issueTokenPair(u)
+}
+
+// validateNewPassword checks a password chosen by the user with phoneNumber against the password policy
+// and reports every violated rule at once.
+func (s *service) validateNewPassword(password, repeatPassword, phoneNumber string) error {
+	fields := map[string][]string{}
+	if password == "" {
+		fields["password"] = []string{"is required"}
+	} else if violations := s.passwords.Check(password, phoneNumber); len(violations) > 0 {
+		fields["password"] = violations
+	}
+	if password != repeatPassword {
+		fields["repeat_password"] = []string{"does not match password"}
+	}
+
+	if len(fields) > 0 {
+		return apperror.ValidationError(fields)
+	}
+	return nil
+}
+
+func (s *service) GenerateAccessToken(u User) ([]byte, error) {
+	pair, err := s.issueTokenPair(u)
 	if err != nil {
 		return nil, err
 	}
-	var u User
-	err = json.Unmarshal(userBytes, &u)
+
+	return json.Marshal(pair)
+}
+
+func (s *service) UpdateRefreshToken(ctx context.Context, rt RT) ([]byte, error) {
+	if rt.RefreshToken == "" {
+		return nil, apperror.UnauthorizedError("refresh token is required")
+	}
+
+	pair, err := s.rotateRefreshToken(ctx, rt.RefreshToken)
 	if err != nil {
 		return nil, err
 	}
-	return s.GenerateAccessToken(u)
+
+	return json.Marshal(pair)
 }
@@ -1,9 +1,97 @@
 package user
 
-import "context"
+import (
+	"context"
+	"fmt"
+
+	"github.com/senizdegen/sdu-housing/user-service/internal/apperror"
+)
 
 type Storage interface {
+	Searcher
 	FindOne(ctx context.Context, uuid string) (User, error)
 	FindByPhoneNumber(ctx context.Context, phoneNumber string) (User, error)
+	// Find returns up to filter.Limit users matching filter, ordered by filter.Sort.
+	Find(ctx context.Context, filter UserFilter) ([]User, error)
 	Create(ctx context.Context, user User) (string, error)
+	// Update replaces the stored user if it still has user.Version and increments the stored version.
+	// It returns apperror.ErrPreconditionFailed if the user was modified in the meantime.
+	Update(ctx context.Context, user User) error
+	// Delete marks the user as deleted at the given unix time.
+	Delete(ctx context.Context, uuid string, at int64) error
+	// Restore undeletes a user deleted after deletedAfter that has not been purged yet.
+	Restore(ctx context.Context, uuid string, deletedAfter int64) error
+	// Purge removes or anonymizes users deleted before deletedBefore and returns their number.
+	Purge(ctx context.Context, deletedBefore int64, anonymize bool) (int64, error)
+}
+
+const (
+	DeletedExclude = "exclude"
+	DeletedOnly    = "only"
+	DeletedInclude = "include"
+
+	SortCreatedAtAsc  = "created_at"
+	SortCreatedAtDesc = "-created_at"
+
+	defaultListLimit = 20
+	maxListLimit     = 100
+)
+
+// UserFilter selects a page of users. Pagination is keyed on the user id,
+// which grows with the creation time, so both sort orders are by creation time.
+type UserFilter struct {
+	Roles []string
+	// CreatedFrom and CreatedTo bound created_at in unix seconds, zero means unbounded.
+	CreatedFrom int64
+	CreatedTo   int64
+	// Deleted is one of DeletedExclude (default), DeletedOnly or DeletedInclude.
+	Deleted string
+	// Sort is SortCreatedAtDesc (default) or SortCreatedAtAsc.
+	Sort string
+	// Cursor is the uuid of the last user of the previous page.
+	Cursor string
+	Limit  int
+}
+
+// Validate checks the filter and fills in defaults.
+func (f *UserFilter) Validate() error {
+	for _, role := range f.Roles {
+		if !isRole(role) {
+			return apperror.BadRequestError(fmt.Sprintf("unknown role %q", role))
+		}
+	}
+	if f.CreatedFrom != 0 && f.CreatedTo != 0 && f.CreatedFrom > f.CreatedTo {
+		return apperror.BadRequestError("created_from must not be after created_to")
+	}
+
+	switch f.Deleted {
+	case "":
+		f.Deleted = DeletedExclude
+	case DeletedExclude, DeletedOnly, DeletedInclude:
+	default:
+		return apperror.BadRequestError("deleted must be one of exclude, only, include")
+	}
+
+	switch f.Sort {
+	case "":
+		f.Sort = SortCreatedAtDesc
+	case SortCreatedAtAsc, SortCreatedAtDesc:
+	default:
+		return apperror.BadRequestError("sort must be created_at or -created_at")
+	}
+
+	switch {
+	case f.Limit == 0:
+		f.Limit = defaultListLimit
+	case f.Limit < 0 || f.Limit > maxListLimit:
+		return apperror.BadRequestError(fmt.Sprintf("limit must be between 1 and %d", maxListLimit))
+	}
+
+	return nil
+}
+
+// UserPage is a page of users, NextCursor is empty on the last page.
+type UserPage struct {
+	Users      []User `json:"users"`
+	NextCursor string `json:"next_cursor,omitempty"`
 }
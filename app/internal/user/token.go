@@ -0,0 +1,252 @@
+package user
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/cristalhq/jwt/v3"
+	"github.com/google/uuid"
+	"github.com/senizdegen/sdu-housing/user-service/internal/apperror"
+	"github.com/senizdegen/sdu-housing/user-service/internal/config"
+	"github.com/senizdegen/sdu-housing/user-service/pkg/cache"
+)
+
+const accessTokenAudience = "users"
+
+const (
+	refreshTokenKeyPrefix = "rt:"
+	tokenFamilyKeyPrefix  = "rtf:"
+)
+
+// refreshToken is the cache entry stored for every issued refresh token.
+// Consumed tokens are kept around so a replay can be recognized.
+type refreshToken struct {
+	FamilyID  string `json:"family_id"`
+	Consumed  bool   `json:"consumed"`
+	ExpiresAt int64  `json:"expires_at"`
+}
+
+// tokenFamily groups all refresh tokens issued from a single login.
+// Only Current may be exchanged; presenting any other member revokes the family.
+// The user is reloaded on every exchange, so access tokens carry its current role.
+type tokenFamily struct {
+	ID       string `json:"id"`
+	UserUUID string `json:"user_uuid"`
+	Current  string `json:"current"`
+	Revoked  bool   `json:"revoked"`
+	// IssuedAt is the login time in unix nanoseconds, compared against logout-all cutoffs.
+	IssuedAt int64 `json:"issued_at"`
+	// ExpiresAt is the absolute end of the session in unix seconds, no refresh is possible after it.
+	ExpiresAt int64 `json:"expires_at"`
+}
+
+// issueTokenPair starts a new token family for u and returns its first token pair.
+func (s *service) issueTokenPair(u User) (pair TokenPair, err error) {
+	now := time.Now()
+	family := tokenFamily{
+		ID:        uuid.New().String(),
+		UserUUID:  u.UUID,
+		IssuedAt:  now.UnixNano(),
+		ExpiresAt: now.Add(config.GetConfig().JWT.SessionLifetime).Unix(),
+	}
+
+	return s.issueFamilyTokenPair(&family, u)
+}
+
+// issueFamilyTokenPair signs a new access token for u and adds a new refresh token to family.
+func (s *service) issueFamilyTokenPair(family *tokenFamily, u User) (pair TokenPair, err error) {
+	accessToken, err := s.signAccessToken(u)
+	if err != nil {
+		return pair, err
+	}
+
+	s.logger.Info("create refresh token")
+	refreshTokenUuid := uuid.New().String()
+	rt := refreshToken{
+		FamilyID:  family.ID,
+		ExpiresAt: time.Now().Add(config.GetConfig().JWT.RefreshTokenTTL).Unix(),
+	}
+	if rt.ExpiresAt > family.ExpiresAt {
+		rt.ExpiresAt = family.ExpiresAt
+	}
+	if err = s.setCacheJSON(refreshTokenKeyPrefix+refreshTokenUuid, rt, expireInUntil(rt.ExpiresAt)); err != nil {
+		s.logger.Error(err)
+		return pair, err
+	}
+
+	family.Current = refreshTokenUuid
+	if err = s.setCacheJSON(tokenFamilyKeyPrefix+family.ID, family, expireInUntil(family.ExpiresAt)); err != nil {
+		s.logger.Error(err)
+		return pair, err
+	}
+
+	return TokenPair{
+		Token:        accessToken,
+		RefreshToken: refreshTokenUuid,
+		ExpiresIn:    int64(config.GetConfig().JWT.AccessTokenTTL.Seconds()),
+	}, nil
+}
+
+func (s *service) signAccessToken(u User) (string, error) {
+	now := time.Now()
+	claims := UserClaims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        uuid.New().String(),
+			Audience:  []string{accessTokenAudience},
+			Subject:   u.UUID,
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(config.GetConfig().JWT.AccessTokenTTL)),
+		},
+		UUID: u.UUID,
+		Role: effectiveRole(u.Role),
+	}
+
+	return s.keys.Sign(claims)
+}
+
+// parseAccessToken verifies the signature, audience and expiry of token and returns its claims.
+// It does not consult the revocation denylist, see VerifyAccessToken.
+func (s *service) parseAccessToken(token string) (claims UserClaims, err error) {
+	parsed, err := s.keys.Verify(token)
+	if err != nil {
+		s.logger.Debugf("access token verification failed. error: %s", err)
+		return claims, apperror.UnauthorizedError("access token is invalid")
+	}
+	if err = json.Unmarshal(parsed.RawClaims(), &claims); err != nil {
+		return claims, apperror.UnauthorizedError("access token is invalid")
+	}
+
+	if !claims.IsForAudience(accessTokenAudience) {
+		return claims, apperror.UnauthorizedError("access token is not issued for this audience")
+	}
+	if claims.ExpiresAt == nil || !claims.IsValidExpiresAt(time.Now()) {
+		return claims, apperror.UnauthorizedError("access token is expired")
+	}
+
+	return claims, nil
+}
+
+// rotateRefreshToken consumes token and issues the next token pair of its family.
+// Presenting a token that was already consumed revokes the whole family.
+func (s *service) rotateRefreshToken(ctx context.Context, token string) (pair TokenPair, err error) {
+	var rt refreshToken
+	if err = s.getCacheJSON(refreshTokenKeyPrefix+token, &rt); err != nil {
+		s.logger.Debugf("refresh token lookup failed. error: %s", err)
+		return pair, apperror.UnauthorizedError("refresh token is invalid or expired")
+	}
+	var family tokenFamily
+	if err = s.getCacheJSON(tokenFamilyKeyPrefix+rt.FamilyID, &family); err != nil {
+		s.logger.Debugf("token family lookup failed. error: %s", err)
+		return pair, apperror.UnauthorizedError("refresh token is invalid or expired")
+	}
+
+	// The user is loaded before the family is locked, so no rotation waits for the database.
+	u, err := s.storage.FindOne(ctx, family.UserUUID)
+	userGone := errors.Is(err, apperror.ErrNotFound)
+	if err != nil && !userGone {
+		return pair, fmt.Errorf("failed to find user of refresh token. error: %w", err)
+	}
+
+	unlock := s.families.Lock(rt.FamilyID)
+	defer unlock()
+
+	// Read again, a concurrent rotation of the family may have consumed token meanwhile.
+	if err = s.getCacheJSON(refreshTokenKeyPrefix+token, &rt); err != nil {
+		return pair, apperror.UnauthorizedError("refresh token is invalid or expired")
+	}
+	if err = s.getCacheJSON(tokenFamilyKeyPrefix+rt.FamilyID, &family); err != nil {
+		return pair, apperror.UnauthorizedError("refresh token is invalid or expired")
+	}
+	if family.Revoked || s.isTokenFamilyRevoked(family) {
+		return pair, apperror.UnauthorizedError("refresh token is revoked")
+	}
+	if time.Now().Unix() >= family.ExpiresAt {
+		return pair, apperror.UnauthorizedError("session is expired, log in again")
+	}
+
+	if rt.Consumed || family.Current != token {
+		s.logger.
+			WithField("event", "refresh_token_reuse").
+			WithField("family_id", family.ID).
+			WithField("user_uuid", family.UserUUID).
+			Warn("security event: consumed refresh token presented, revoking token family")
+		if err = s.revokeTokenFamily(&family); err != nil {
+			return pair, err
+		}
+		return pair, apperror.UnauthorizedError("refresh token is revoked")
+	}
+
+	if userGone {
+		s.logger.Infof("user %s of token family %s is gone, revoking token family", family.UserUUID, family.ID)
+		if err = s.revokeTokenFamily(&family); err != nil {
+			return pair, err
+		}
+		return pair, apperror.UnauthorizedError("refresh token is revoked")
+	}
+	// The denylist cutoff only lives in the cache, the stored password change survives restarts.
+	if family.IssuedAt < time.Unix(u.PasswordChangedAt, 0).UnixNano() {
+		if err = s.revokeTokenFamily(&family); err != nil {
+			return pair, err
+		}
+		return pair, apperror.UnauthorizedError("password was changed, log in again")
+	}
+
+	rt.Consumed = true
+	if err = s.setCacheJSON(refreshTokenKeyPrefix+token, rt, expireInUntil(rt.ExpiresAt)); err != nil {
+		return pair, err
+	}
+
+	return s.issueFamilyTokenPair(&family, u)
+}
+
+// revokeTokenFamily marks family as revoked and drops its current refresh token.
+func (s *service) revokeTokenFamily(family *tokenFamily) error {
+	s.rtCache.Del([]byte(refreshTokenKeyPrefix + family.Current))
+
+	family.Revoked = true
+	family.Current = ""
+	if err := s.setCacheJSON(tokenFamilyKeyPrefix+family.ID, family, expireInUntil(family.ExpiresAt)); err != nil {
+		return fmt.Errorf("failed to revoke token family. error: %w", err)
+	}
+
+	return nil
+}
+
+// expireInUntil converts a unix timestamp into a cache expireIn value.
+// The result is at least one second, as expireIn <= 0 means no expiry.
+func expireInUntil(unix int64) int {
+	expireIn := int(unix - time.Now().Unix())
+	if expireIn < 1 {
+		return 1
+	}
+	return expireIn
+}
+
+func (s *service) setCacheJSON(key string, v interface{}, expireIn int) error {
+	return setJSON(s.rtCache, key, v, expireIn)
+}
+
+func (s *service) getCacheJSON(key string, v interface{}) error {
+	return getJSON(s.rtCache, key, v)
+}
+
+func setJSON(c cache.Repository, key string, v interface{}, expireIn int) error {
+	bytes, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("failed to marshal cache entry. error: %w", err)
+	}
+
+	return c.Set([]byte(key), bytes, expireIn)
+}
+
+func getJSON(c cache.Repository, key string, v interface{}) error {
+	bytes, err := c.Get([]byte(key))
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(bytes, v)
+}
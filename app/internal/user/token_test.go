@@ -0,0 +1,291 @@
+package user
+
+import (
+	"context"
+	"errors"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/cristalhq/jwt/v3"
+	"github.com/senizdegen/sdu-housing/user-service/internal/apperror"
+	"github.com/senizdegen/sdu-housing/user-service/internal/config"
+	"github.com/senizdegen/sdu-housing/user-service/pkg/cache/freecache"
+	"github.com/senizdegen/sdu-housing/user-service/pkg/keyring"
+	"github.com/senizdegen/sdu-housing/user-service/pkg/logging"
+)
+
+const testConfig = `
+mongodb:
+  host: localhost
+  port: "27017"
+  auth_db: admin
+  database: test
+  collection: users
+`
+
+// TestMain loads the default config from a temporary directory, as GetConfig reads .env and config.yml
+// from the working directory.
+func TestMain(m *testing.M) {
+	os.Exit(func() int {
+		wd, err := os.Getwd()
+		if err != nil {
+			panic(err)
+		}
+		dir, err := os.MkdirTemp("", "user-test")
+		if err != nil {
+			panic(err)
+		}
+		defer os.RemoveAll(dir)
+
+		if err = os.Chdir(dir); err != nil {
+			panic(err)
+		}
+		if err = os.WriteFile(".env", nil, 0o600); err != nil {
+			panic(err)
+		}
+		if err = os.WriteFile("config.yml", []byte(testConfig), 0o600); err != nil {
+			panic(err)
+		}
+		logging.Init()
+		config.GetConfig()
+		if err = os.Chdir(wd); err != nil {
+			panic(err)
+		}
+
+		return m.Run()
+	}())
+}
+
+// stubStorage keeps users in memory, only FindOne is used by token rotation.
+type stubStorage struct {
+	Storage
+
+	mu    sync.Mutex
+	users map[string]User
+}
+
+func (s *stubStorage) FindOne(ctx context.Context, uuid string) (User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	u, ok := s.users[uuid]
+	if !ok {
+		return User{}, apperror.ErrNotFound
+	}
+	return u, nil
+}
+
+func (s *stubStorage) put(u User) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.users[u.UUID] = u
+}
+
+func (s *stubStorage) remove(uuid string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.users, uuid)
+}
+
+func newTestService(t *testing.T) (*service, *stubStorage) {
+	t.Helper()
+
+	key, err := keyring.NewHMACKey(jwt.HS256, []byte("test secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys, err := keyring.New(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	storage := &stubStorage{users: map[string]User{}}
+	s := &service{
+		storage:      storage,
+		logger:       logging.GetLogger(),
+		rtCache:      freecache.NewCacheRepo(1024 * 1024),
+		lockoutCache: freecache.NewCacheRepo(1024 * 1024),
+		keys:         keys,
+	}
+	return s, storage
+}
+
+func testUser() User {
+	return User{UUID: "5f1d7b2e9c4a3b0012345678", PhoneNumber: "+77011234567", Role: RoleStudent}
+}
+
+func wantUnauthorized(t *testing.T, err error, what string) {
+	t.Helper()
+
+	var appErr *apperror.AppError
+	if !errors.As(err, &appErr) || appErr.Code != apperror.UnauthorizedError("").Code {
+		t.Fatalf("%s: error = %v, want unauthorized", what, err)
+	}
+}
+
+func TestRotateRefreshToken(t *testing.T) {
+	s, storage := newTestService(t)
+	u := testUser()
+	storage.put(u)
+
+	first, err := s.issueTokenPair(u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := s.rotateRefreshToken(context.Background(), first.RefreshToken)
+	if err != nil {
+		t.Fatalf("rotating a fresh token: %v", err)
+	}
+	if second.RefreshToken == first.RefreshToken || second.Token == "" {
+		t.Fatalf("rotation returned %+v, want a new token pair", second)
+	}
+	third, err := s.rotateRefreshToken(context.Background(), second.RefreshToken)
+	if err != nil {
+		t.Fatalf("rotating the next token: %v", err)
+	}
+
+	claims, err := s.VerifyAccessToken(third.Token)
+	if err != nil || claims.UUID != u.UUID {
+		t.Errorf("access token of rotation = %+v, %v, want one of user %s", claims, err, u.UUID)
+	}
+}
+
+func TestRotateRefreshTokenReuseRevokesFamily(t *testing.T) {
+	s, storage := newTestService(t)
+	u := testUser()
+	storage.put(u)
+
+	first, err := s.issueTokenPair(u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := s.rotateRefreshToken(context.Background(), first.RefreshToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = s.rotateRefreshToken(context.Background(), first.RefreshToken)
+	wantUnauthorized(t, err, "replaying a consumed token")
+
+	_, err = s.rotateRefreshToken(context.Background(), second.RefreshToken)
+	wantUnauthorized(t, err, "rotating the current token after a replay")
+
+	// other sessions of the user are not affected
+	other, err := s.issueTokenPair(u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = s.rotateRefreshToken(context.Background(), other.RefreshToken); err != nil {
+		t.Errorf("rotating a token of another family: %v", err)
+	}
+}
+
+func TestRotateRefreshTokenConcurrentUse(t *testing.T) {
+	s, storage := newTestService(t)
+	u := testUser()
+	storage.put(u)
+
+	pair, err := s.issueTokenPair(u)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const attempts = 10
+	var wg sync.WaitGroup
+	results := make(chan error, attempts)
+	for i := 0; i < attempts; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := s.rotateRefreshToken(context.Background(), pair.RefreshToken)
+			results <- err
+		}()
+	}
+	wg.Wait()
+	close(results)
+
+	succeeded := 0
+	for err := range results {
+		if err == nil {
+			succeeded++
+		}
+	}
+	if succeeded != 1 {
+		t.Errorf("%d of %d concurrent rotations of one token succeeded, want 1", succeeded, attempts)
+	}
+}
+
+func TestRotateRefreshTokenRevoked(t *testing.T) {
+	tests := []struct {
+		name   string
+		revoke func(t *testing.T, s *service, storage *stubStorage, pair TokenPair)
+	}{
+		{"logout", func(t *testing.T, s *service, storage *stubStorage, pair TokenPair) {
+			if err := s.revokeRefreshToken(testUser().UUID, pair.RefreshToken); err != nil {
+				t.Fatal(err)
+			}
+		}},
+		{"logout everywhere", func(t *testing.T, s *service, storage *stubStorage, pair TokenPair) {
+			if err := s.revokeUserTokens(testUser().UUID, time.Now()); err != nil {
+				t.Fatal(err)
+			}
+		}},
+		{"password changed", func(t *testing.T, s *service, storage *stubStorage, pair TokenPair) {
+			u := testUser()
+			u.PasswordChangedAt = time.Now().Add(time.Second).Unix()
+			storage.put(u)
+		}},
+		{"user deleted", func(t *testing.T, s *service, storage *stubStorage, pair TokenPair) {
+			storage.remove(testUser().UUID)
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, storage := newTestService(t)
+			u := testUser()
+			storage.put(u)
+
+			pair, err := s.issueTokenPair(u)
+			if err != nil {
+				t.Fatal(err)
+			}
+			tt.revoke(t, s, storage, pair)
+
+			_, err = s.rotateRefreshToken(context.Background(), pair.RefreshToken)
+			wantUnauthorized(t, err, "rotating a revoked token")
+
+			// the family stays revoked even if the cause goes away
+			storage.put(u)
+			_, err = s.rotateRefreshToken(context.Background(), pair.RefreshToken)
+			wantUnauthorized(t, err, "rotating a revoked token again")
+		})
+	}
+}
+
+func TestRevokeRefreshTokenOfOtherUser(t *testing.T) {
+	s, storage := newTestService(t)
+	u := testUser()
+	storage.put(u)
+
+	pair, err := s.issueTokenPair(u)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = s.revokeRefreshToken("000000000000000000000000", pair.RefreshToken)
+	wantUnauthorized(t, err, "revoking the token of another user")
+
+	if _, err = s.rotateRefreshToken(context.Background(), pair.RefreshToken); err != nil {
+		t.Errorf("token revoked by another user: %v", err)
+	}
+}
+
+func TestRotateRefreshTokenUnknown(t *testing.T) {
+	s, _ := newTestService(t)
+
+	_, err := s.rotateRefreshToken(context.Background(), "unknown")
+	wantUnauthorized(t, err, "rotating an unknown token")
+}
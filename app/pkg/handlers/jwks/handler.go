@@ -0,0 +1,36 @@
+package jwks
+
+import (
+	"net/http"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/senizdegen/sdu-housing/user-service/pkg/keyring"
+	"github.com/senizdegen/sdu-housing/user-service/pkg/logging"
+)
+
+const (
+	URL = "/.well-known/jwks.json"
+)
+
+type Handler struct {
+	Logger  logging.Logger
+	Keyring *keyring.Keyring
+}
+
+func (h *Handler) Register(router *httprouter.Router) {
+	router.HandlerFunc(http.MethodGet, URL, h.JWKS)
+}
+
+func (h *Handler) JWKS(w http.ResponseWriter, req *http.Request) {
+	keysBytes, err := h.Keyring.JWKS().Marshal()
+	if err != nil {
+		h.Logger.Errorf("failed to marshal jwks. error: %s", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	w.WriteHeader(http.StatusOK)
+	w.Write(keysBytes)
+}
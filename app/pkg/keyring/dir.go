@@ -0,0 +1,124 @@
+package keyring
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	activeFile = "active"
+	keyExt     = ".pem"
+)
+
+// LoadDir loads every <kid>.pem key of dir, the one named by the active file becomes the active key.
+func LoadDir(dir string) (*Keyring, error) {
+	activeID, err := ActiveID(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	keys, err := ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var active *Key
+	var retired []*Key
+	for _, key := range keys {
+		if key.ID == activeID {
+			active = key
+			continue
+		}
+		retired = append(retired, key)
+	}
+	if active == nil {
+		return nil, fmt.Errorf("active key %q not found in %s", activeID, dir)
+	}
+
+	return New(active, retired...)
+}
+
+// ReadDir reads every <kid>.pem key of dir and checks that its file name matches its thumbprint.
+func ReadDir(dir string) ([]*Key, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*"+keyExt))
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make([]*Key, 0, len(paths))
+	for _, path := range paths {
+		key, err := LoadPrivateKey(path)
+		if err != nil {
+			return nil, err
+		}
+		if name := strings.TrimSuffix(filepath.Base(path), keyExt); name != key.ID {
+			return nil, fmt.Errorf("key file %s does not match its kid %s", path, key.ID)
+		}
+		keys = append(keys, key)
+	}
+
+	return keys, nil
+}
+
+// ActiveID returns the kid stored in the active file of dir.
+func ActiveID(dir string) (string, error) {
+	activeBytes, err := os.ReadFile(filepath.Join(dir, activeFile))
+	if err != nil {
+		return "", fmt.Errorf("failed to read active key id. error: %w", err)
+	}
+
+	activeID := strings.TrimSpace(string(activeBytes))
+	if activeID == "" {
+		return "", fmt.Errorf("active key id in %s is empty", dir)
+	}
+
+	return activeID, nil
+}
+
+// WriteKey stores pemBytes of key as <kid>.pem in dir.
+func WriteKey(dir string, key *Key, pemBytes []byte) error {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+
+	path := filepath.Join(dir, key.ID+keyExt)
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to create key file. error: %w", err)
+	}
+	defer file.Close()
+
+	_, err = file.Write(pemBytes)
+	return err
+}
+
+// Promote makes the key kid of dir the active one.
+func Promote(dir, kid string) error {
+	if _, err := os.Stat(filepath.Join(dir, kid+keyExt)); err != nil {
+		return fmt.Errorf("key %s not found in %s. error: %w", kid, dir, err)
+	}
+
+	// Write and rename so a concurrent reader never sees a partial file.
+	tmp := filepath.Join(dir, activeFile+".tmp")
+	if err := os.WriteFile(tmp, []byte(kid+"\n"), 0600); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, filepath.Join(dir, activeFile))
+}
+
+// Remove deletes the retired key kid from dir. The active key cannot be removed.
+func Remove(dir, kid string) error {
+	activeID, err := ActiveID(dir)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	if kid == activeID {
+		return fmt.Errorf("key %s is active, promote another key first", kid)
+	}
+
+	return os.Remove(filepath.Join(dir, kid+keyExt))
+}
@@ -0,0 +1,226 @@
+package keyring
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"os"
+	"time"
+
+	"github.com/cristalhq/jwt/v3"
+)
+
+// minRSABits is the smallest RSA modulus accepted for RS256.
+const minRSABits = 2048
+
+// Key is a single signing key. Asymmetric keys are identified by their RFC 7638 thumbprint.
+type Key struct {
+	ID        string
+	Algorithm jwt.Algorithm
+	// IssuedBefore, unless zero, rejects tokens without iat or issued at or after it.
+	// It retires a key for tokens of a given age, e.g. a legacy secret that should have stopped signing.
+	IssuedBefore time.Time
+
+	signer   jwt.Signer
+	verifier jwt.Verifier
+	public   crypto.PublicKey
+}
+
+// NewHMACKey creates a legacy shared secret key. It has no ID and is never published.
+// Tokens signed by it carry no kid header.
+func NewHMACKey(alg jwt.Algorithm, secret []byte) (*Key, error) {
+	if len(secret) == 0 {
+		return nil, fmt.Errorf("hmac secret is empty")
+	}
+	signer, err := jwt.NewSignerHS(alg, secret)
+	if err != nil {
+		return nil, err
+	}
+	verifier, err := jwt.NewVerifierHS(alg, secret)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Key{Algorithm: alg, signer: signer, verifier: verifier}, nil
+}
+
+// GenerateKey creates a new RS256 or EdDSA key and returns it with its PKCS #8 PEM encoding.
+func GenerateKey(alg jwt.Algorithm) (*Key, []byte, error) {
+	var private interface{}
+	var err error
+	switch alg {
+	case jwt.RS256:
+		private, err = rsa.GenerateKey(rand.Reader, minRSABits)
+	case jwt.EdDSA:
+		_, private, err = ed25519.GenerateKey(rand.Reader)
+	default:
+		return nil, nil, fmt.Errorf("unsupported algorithm %q", alg)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	der, err := x509.MarshalPKCS8PrivateKey(private)
+	if err != nil {
+		return nil, nil, err
+	}
+	key, err := newKey(private)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return key, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), nil
+}
+
+// LoadPrivateKey reads a PEM encoded private key from path.
+func LoadPrivateKey(path string) (*Key, error) {
+	pemBytes, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read private key file. error: %w", err)
+	}
+
+	key, err := ParsePrivateKey(pemBytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load private key %s. error: %w", path, err)
+	}
+
+	return key, nil
+}
+
+// ParsePrivateKey parses a PKCS #1 or PKCS #8 PEM block holding an RSA or Ed25519 key.
+// RSA keys sign with RS256, Ed25519 keys with EdDSA.
+func ParsePrivateKey(pemBytes []byte) (*Key, error) {
+	block, _ := pem.Decode(pemBytes)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM block found")
+	}
+
+	var private interface{}
+	var err error
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		private, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		private, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("unsupported PEM block type %q", block.Type)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return newKey(private)
+}
+
+func newKey(private interface{}) (*Key, error) {
+	key := &Key{}
+
+	var err error
+	switch private := private.(type) {
+	case *rsa.PrivateKey:
+		rsaKey := private
+		key.Algorithm = jwt.RS256
+		if rsaKey.N.BitLen() < minRSABits {
+			return nil, fmt.Errorf("RSA key must be at least %d bits", minRSABits)
+		}
+		key.public = &rsaKey.PublicKey
+		if key.signer, err = jwt.NewSignerRS(key.Algorithm, rsaKey); err != nil {
+			return nil, err
+		}
+		if key.verifier, err = jwt.NewVerifierRS(key.Algorithm, &rsaKey.PublicKey); err != nil {
+			return nil, err
+		}
+	case ed25519.PrivateKey:
+		edKey := private
+		key.Algorithm = jwt.EdDSA
+		public := edKey.Public().(ed25519.PublicKey)
+		key.public = public
+		if key.signer, err = jwt.NewSignerEdDSA(edKey); err != nil {
+			return nil, err
+		}
+		if key.verifier, err = jwt.NewVerifierEdDSA(public); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unsupported key type %T", private)
+	}
+
+	jwk, _ := key.JWK()
+	key.ID = jwk.Thumbprint()
+
+	return key, nil
+}
+
+// JWK is a public JSON Web Key, see RFC 7517 and RFC 8037.
+type JWK struct {
+	Kty string `json:"kty"`
+	Use string `json:"use,omitempty"`
+	Alg string `json:"alg,omitempty"`
+	Kid string `json:"kid,omitempty"`
+	// RSA
+	N string `json:"n,omitempty"`
+	E string `json:"e,omitempty"`
+	// OKP
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+}
+
+// JWK returns the public part of k. HMAC keys have no public part.
+func (k *Key) JWK() (JWK, bool) {
+	switch public := k.public.(type) {
+	case *rsa.PublicKey:
+		return JWK{
+			Kty: "RSA",
+			Use: "sig",
+			Alg: string(k.Algorithm),
+			Kid: k.ID,
+			N:   base64.RawURLEncoding.EncodeToString(public.N.Bytes()),
+			E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(public.E)).Bytes()),
+		}, true
+	case ed25519.PublicKey:
+		return JWK{
+			Kty: "OKP",
+			Use: "sig",
+			Alg: string(k.Algorithm),
+			Kid: k.ID,
+			Crv: "Ed25519",
+			X:   base64.RawURLEncoding.EncodeToString(public),
+		}, true
+	}
+
+	return JWK{}, false
+}
+
+// Thumbprint computes the RFC 7638 SHA-256 thumbprint of the key.
+func (j JWK) Thumbprint() string {
+	// Required members only, in lexicographic order.
+	var members interface{}
+	switch j.Kty {
+	case "RSA":
+		members = struct {
+			E   string `json:"e"`
+			Kty string `json:"kty"`
+			N   string `json:"n"`
+		}{j.E, j.Kty, j.N}
+	case "OKP":
+		members = struct {
+			Crv string `json:"crv"`
+			Kty string `json:"kty"`
+			X   string `json:"x"`
+		}{j.Crv, j.Kty, j.X}
+	default:
+		return ""
+	}
+
+	canonical, _ := json.Marshal(members)
+	sum := sha256.Sum256(canonical)
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
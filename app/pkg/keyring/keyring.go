@@ -0,0 +1,144 @@
+// Package keyring holds the keys used to sign and verify JWTs
+// and publishes the public ones as a JSON Web Key Set.
+//
+// A keyring has one active key that signs new tokens and any number of retired keys
+// that only verify tokens issued before a rotation. Keys live in a directory as
+// <kid>.pem files, the kid of the active key is stored in the "active" file next to them.
+//
+// Rotation procedure:
+//
+//  1. Generate the next key with "keys generate". It is loaded as a retired key,
+//     so after a restart it is published in the JWKS but signs nothing yet.
+//  2. Once every consumer has refreshed its JWKS cache, promote it with "keys promote <kid>"
+//     and restart. "keys rotate" does both steps at once for setups where consumers
+//     refetch the JWKS on an unknown kid.
+//  3. After the access token TTL has passed, no token signed by the old key is valid anymore.
+//     Remove it with "keys remove <kid>" and restart.
+package keyring
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	"github.com/cristalhq/jwt/v3"
+)
+
+// Keyring signs tokens with its active key and verifies them with the key named by the kid header.
+type Keyring struct {
+	active *Key
+	keys   map[string]*Key
+}
+
+// New creates a keyring that signs with active and also verifies with retired.
+func New(active *Key, retired ...*Key) (*Keyring, error) {
+	if active == nil || active.signer == nil {
+		return nil, fmt.Errorf("active key must be able to sign")
+	}
+
+	k := &Keyring{
+		active: active,
+		keys:   map[string]*Key{active.ID: active},
+	}
+	for _, key := range retired {
+		if err := k.Add(key); err != nil {
+			return nil, err
+		}
+	}
+
+	return k, nil
+}
+
+// Add adds a retired key that is only used for verification.
+// It must not be called once the keyring is in use.
+func (k *Keyring) Add(key *Key) error {
+	if _, ok := k.keys[key.ID]; ok {
+		return fmt.Errorf("duplicate key %q", key.ID)
+	}
+	k.keys[key.ID] = key
+
+	return nil
+}
+
+// Active returns the key new tokens are signed with.
+func (k *Keyring) Active() *Key {
+	return k.active
+}
+
+// Keys returns every key of the keyring ordered by kid.
+func (k *Keyring) Keys() []*Key {
+	keys := make([]*Key, 0, len(k.keys))
+	for _, key := range k.keys {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].ID < keys[j].ID })
+
+	return keys
+}
+
+// Sign builds a token for claims signed with the active key.
+func (k *Keyring) Sign(claims interface{}) (string, error) {
+	var opts []jwt.BuilderOption
+	if k.active.ID != "" {
+		opts = append(opts, jwt.WithKeyID(k.active.ID))
+	}
+
+	token, err := jwt.NewBuilder(k.active.signer, opts...).Build(claims)
+	if err != nil {
+		return "", fmt.Errorf("failed to sign token. error: %w", err)
+	}
+
+	return token.String(), nil
+}
+
+// Verify parses token and checks its signature with the key named by its kid header.
+// Tokens without kid can only be verified by a legacy HMAC key.
+// Keys with IssuedBefore also check the iat claim.
+func (k *Keyring) Verify(token string) (*jwt.Token, error) {
+	parsed, err := jwt.ParseString(token)
+	if err != nil {
+		return nil, err
+	}
+
+	key, ok := k.keys[parsed.Header().KeyID]
+	if !ok {
+		return nil, fmt.Errorf("unknown signing key %q", parsed.Header().KeyID)
+	}
+
+	verified, err := jwt.ParseAndVerifyString(token, key.verifier)
+	if err != nil || key.IssuedBefore.IsZero() {
+		return verified, err
+	}
+
+	var claims jwt.StandardClaims
+	if err = json.Unmarshal(verified.RawClaims(), &claims); err != nil {
+		return nil, err
+	}
+	if claims.IssuedAt == nil || !claims.IssuedAt.Before(key.IssuedBefore) {
+		return nil, fmt.Errorf("token was issued after key %q was retired", key.ID)
+	}
+
+	return verified, nil
+}
+
+// JWKS returns the public keys of the keyring. HMAC keys are never published.
+func (k *Keyring) JWKS() JWKS {
+	set := JWKS{Keys: []JWK{}}
+	for _, key := range k.Keys() {
+		if jwk, ok := key.JWK(); ok {
+			set.Keys = append(set.Keys, jwk)
+		}
+	}
+
+	return set
+}
+
+// JWKS is a JSON Web Key Set, see RFC 7517.
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+// Marshal returns the key set as JSON.
+func (s JWKS) Marshal() ([]byte, error) {
+	return json.Marshal(s)
+}
@@ -0,0 +1,223 @@
+package keyring
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/cristalhq/jwt/v3"
+)
+
+func generateKey(t *testing.T, alg jwt.Algorithm) *Key {
+	t.Helper()
+
+	key, _, err := GenerateKey(alg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func hmacKey(t *testing.T, secret string) *Key {
+	t.Helper()
+
+	key, err := NewHMACKey(jwt.HS256, []byte(secret))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func newKeyring(t *testing.T, active *Key, retired ...*Key) *Keyring {
+	t.Helper()
+
+	keys, err := New(active, retired...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return keys
+}
+
+func sign(t *testing.T, keys *Keyring, claims interface{}) string {
+	t.Helper()
+
+	token, err := keys.Sign(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+func TestSignVerify(t *testing.T) {
+	tests := []struct {
+		name  string
+		key   *Key
+		other *Key
+	}{
+		{"RS256", generateKey(t, jwt.RS256), generateKey(t, jwt.RS256)},
+		{"EdDSA", generateKey(t, jwt.EdDSA), generateKey(t, jwt.EdDSA)},
+		{"HS256", hmacKey(t, "secret"), hmacKey(t, "other secret")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			keys := newKeyring(t, tt.key)
+			token := sign(t, keys, jwt.StandardClaims{Subject: "user"})
+
+			verified, err := keys.Verify(token)
+			if err != nil {
+				t.Fatalf("Verify: %v", err)
+			}
+			if got := verified.Header().Algorithm; got != tt.key.Algorithm {
+				t.Errorf("alg = %s, want %s", got, tt.key.Algorithm)
+			}
+			if got := verified.Header().KeyID; got != tt.key.ID {
+				t.Errorf("kid = %q, want %q", got, tt.key.ID)
+			}
+
+			if _, err = newKeyring(t, tt.other).Verify(token); err == nil {
+				t.Error("token verified by another key")
+			}
+			tampered := token[:len(token)-4] + "AAAA"
+			if tampered == token {
+				tampered = token[:len(token)-4] + "BBBB"
+			}
+			if _, err = keys.Verify(tampered); err == nil {
+				t.Error("token with a tampered signature verified")
+			}
+		})
+	}
+}
+
+func TestVerifySelectsKeyByKid(t *testing.T) {
+	active := generateKey(t, jwt.EdDSA)
+	retiredRSA := generateKey(t, jwt.RS256)
+	retiredEdDSA := generateKey(t, jwt.EdDSA)
+	keys := newKeyring(t, active, retiredRSA, retiredEdDSA)
+
+	tests := []struct {
+		name    string
+		signer  *Keyring
+		wantErr bool
+	}{
+		{"active key", newKeyring(t, active), false},
+		{"retired RS256 key", newKeyring(t, retiredRSA), false},
+		{"retired EdDSA key", newKeyring(t, retiredEdDSA), false},
+		{"unknown key", newKeyring(t, generateKey(t, jwt.EdDSA)), true},
+		{"no kid without a legacy key", newKeyring(t, hmacKey(t, "secret")), true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := keys.Verify(sign(t, tt.signer, jwt.StandardClaims{Subject: "user"}))
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Verify error = %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+
+	verified, err := keys.Verify(sign(t, keys, jwt.StandardClaims{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := verified.Header().KeyID; got != active.ID {
+		t.Errorf("keyring signed with kid %q, want the active key %q", got, active.ID)
+	}
+}
+
+func TestVerifyLegacyKeyIssuedBefore(t *testing.T) {
+	switchedAt := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	legacy := hmacKey(t, "legacy secret")
+	legacy.IssuedBefore = switchedAt
+	keys := newKeyring(t, generateKey(t, jwt.RS256), legacy)
+	legacySigner := newKeyring(t, hmacKey(t, "legacy secret"))
+
+	tests := []struct {
+		name     string
+		issuedAt *jwt.NumericDate
+		wantErr  bool
+	}{
+		{"issued before the switch", jwt.NewNumericDate(switchedAt.Add(-time.Minute)), false},
+		{"issued at the switch", jwt.NewNumericDate(switchedAt), true},
+		{"issued after the switch", jwt.NewNumericDate(switchedAt.Add(time.Minute)), true},
+		{"without iat", nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			token := sign(t, legacySigner, jwt.StandardClaims{Subject: "user", IssuedAt: tt.issuedAt})
+			if _, err := keys.Verify(token); (err != nil) != tt.wantErr {
+				t.Errorf("Verify error = %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestJWKS(t *testing.T) {
+	rsaKey := generateKey(t, jwt.RS256)
+	edKey := generateKey(t, jwt.EdDSA)
+	keys := newKeyring(t, rsaKey, edKey, hmacKey(t, "legacy secret"))
+
+	set := keys.JWKS()
+	if len(set.Keys) != 2 {
+		t.Fatalf("JWKS has %d keys, want 2, the HMAC key must not be published", len(set.Keys))
+	}
+	if set.Keys[0].Kid > set.Keys[1].Kid {
+		t.Errorf("JWKS keys are not ordered by kid: %q, %q", set.Keys[0].Kid, set.Keys[1].Kid)
+	}
+
+	want := map[string]JWK{
+		rsaKey.ID: {Kty: "RSA", Use: "sig", Alg: "RS256", Kid: rsaKey.ID},
+		edKey.ID:  {Kty: "OKP", Use: "sig", Alg: "EdDSA", Kid: edKey.ID, Crv: "Ed25519"},
+	}
+	for _, jwk := range set.Keys {
+		w, ok := want[jwk.Kid]
+		if !ok {
+			t.Errorf("JWKS has unexpected kid %q", jwk.Kid)
+			continue
+		}
+		if jwk.Kty != w.Kty || jwk.Use != w.Use || jwk.Alg != w.Alg || jwk.Crv != w.Crv {
+			t.Errorf("JWK %q = %+v, want %+v", jwk.Kid, jwk, w)
+		}
+		if jwk.Thumbprint() != jwk.Kid {
+			t.Errorf("kid %q is not the thumbprint of the key", jwk.Kid)
+		}
+	}
+
+	data, err := set.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded struct {
+		Keys []map[string]string `json:"keys"`
+	}
+	if err = json.Unmarshal(data, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	for _, jwk := range decoded.Keys {
+		for _, private := range []string{"d", "p", "q", "k"} {
+			if _, ok := jwk[private]; ok {
+				t.Errorf("JWK %q publishes private member %q", jwk["kid"], private)
+			}
+		}
+	}
+
+	if got := newKeyring(t, hmacKey(t, "secret")).JWKS(); got.Keys == nil || len(got.Keys) != 0 {
+		t.Errorf("JWKS of an HMAC keyring = %+v, want an empty set", got)
+	}
+}
+
+func TestThumbprint(t *testing.T) {
+	// RFC 7638, section 3.1
+	jwk := JWK{
+		Kty: "RSA",
+		N:   "0vx7agoebGcQSuuPiLJXZptN9nndrQmbXEps2aiAFbWhM78LhWx4cbbfAAtVT86zwu1RK7aPFFxuhDR1L6tSoc_BJECPebWKRXjBZCiFV4n3oknjhMstn64tZ_2W-5JsGY4Hc5n9yBXArwl93lqt7_RN5w6Cf0h4QyQ5v-65YGjQR0_FDW2QvzqY368QQMicAtaSqzs8KJZgnYb9c7d0zgdAZHzu6qMQvRL5hajrn1n91CbOpbISD08qNLyrdkt-bFTWhAI4vMQFh6WeZu0fM4lFd2NcRwr3XPksINHaQ-G_xBniIqbw0Ls1jF44-csFCur-kEgU8awapJzKnqDKgw",
+		E:   "AQAB",
+		Alg: "RS256",
+		Kid: "2011-04-29",
+	}
+	if got, want := jwk.Thumbprint(), "NzbLsXh8uDCcd-6MNwXF4W_7noWXFZAfHkxZsRGC9Xs"; got != want {
+		t.Errorf("Thumbprint = %s, want %s", got, want)
+	}
+}
@@ -0,0 +1,121 @@
+// Package phone parses phone numbers written in national or international format into E.164.
+package phone
+
+import (
+	"errors"
+	"strings"
+)
+
+// DefaultRegion is used for numbers written without a country code.
+const DefaultRegion = "KZ"
+
+const (
+	minE164Digits = 8
+	maxE164Digits = 15
+)
+
+var ErrInvalid = errors.New("invalid phone number")
+
+type region struct {
+	countryCode    string
+	trunkPrefix    string
+	nationalLength int
+}
+
+var regions = map[string]region{
+	// Kazakhstan shares +7 with Russia, national numbers are dialed with the 8 trunk prefix.
+	"KZ": {countryCode: "7", trunkPrefix: "8", nationalLength: 10},
+}
+
+// AcceptedFormats are examples of the formats Normalize accepts for DefaultRegion.
+var AcceptedFormats = []string{
+	"+7 701 123 4567",
+	"8 701 123 4567",
+	"7 701 123 4567",
+	"701 123 4567",
+	"+<country code> <number> for other countries",
+}
+
+// Normalize returns raw in E.164 format, e.g. "+77011234567".
+// Spaces, dashes, dots and parentheses are ignored. Numbers without a leading + or 00
+// are national numbers of regionCode.
+func Normalize(raw, regionCode string) (string, error) {
+	reg, ok := regions[regionCode]
+	if !ok {
+		return "", errors.New("unsupported phone region " + regionCode)
+	}
+
+	raw = strings.TrimSpace(raw)
+	international := strings.HasPrefix(raw, "+")
+	digits, ok := Digits(strings.TrimPrefix(raw, "+"))
+	if !ok || digits == "" {
+		return "", ErrInvalid
+	}
+	if !international && strings.HasPrefix(digits, "00") {
+		international = true
+		digits = digits[2:]
+	}
+
+	if !international {
+		switch {
+		case len(digits) == reg.nationalLength:
+		case len(digits) == reg.nationalLength+1 && strings.HasPrefix(digits, reg.trunkPrefix):
+			digits = digits[len(reg.trunkPrefix):]
+		case len(digits) == reg.nationalLength+len(reg.countryCode) && strings.HasPrefix(digits, reg.countryCode):
+			digits = digits[len(reg.countryCode):]
+		default:
+			return "", ErrInvalid
+		}
+		// National numbers cannot start with the trunk prefix, "8 870 112 3456" is mistyped.
+		// In international format they can, "+7 800 555 3535" is a valid number.
+		if strings.HasPrefix(digits, reg.trunkPrefix) {
+			return "", ErrInvalid
+		}
+		digits = reg.countryCode + digits
+	}
+
+	if len(digits) < minE164Digits || len(digits) > maxE164Digits || digits[0] == '0' {
+		return "", ErrInvalid
+	}
+	// Numbers of a known country code must have its national length.
+	for _, known := range regions {
+		if strings.HasPrefix(digits, known.countryCode) && len(digits) != len(known.countryCode)+known.nationalLength {
+			return "", ErrInvalid
+		}
+	}
+
+	return "+" + digits, nil
+}
+
+// NormalizePrefix turns the beginning of a phone number into the digits of its E.164 form,
+// without the +, so it can be matched against normalized numbers.
+func NormalizePrefix(raw, regionCode string) (string, bool) {
+	raw = strings.TrimSpace(raw)
+	international := strings.HasPrefix(raw, "+")
+	digits, ok := Digits(strings.TrimPrefix(raw, "+"))
+	if !ok || digits == "" {
+		return "", false
+	}
+
+	reg, ok := regions[regionCode]
+	if !international && ok && strings.HasPrefix(digits, reg.trunkPrefix) {
+		digits = reg.countryCode + digits[len(reg.trunkPrefix):]
+	}
+
+	return digits, true
+}
+
+// Digits strips the separators allowed in phone numbers and reports false on any other character.
+func Digits(s string) (string, bool) {
+	var b strings.Builder
+	for _, r := range s {
+		switch {
+		case r >= '0' && r <= '9':
+			b.WriteRune(r)
+		case r == ' ' || r == '-' || r == '.' || r == '(' || r == ')':
+		default:
+			return "", false
+		}
+	}
+	return b.String(), true
+}
@@ -0,0 +1,91 @@
+package phone
+
+import "testing"
+
+func TestNormalize(t *testing.T) {
+	tests := []struct {
+		raw     string
+		want    string
+		wantErr bool
+	}{
+		{raw: "+7 701 123 4567", want: "+77011234567"},
+		{raw: "+77011234567", want: "+77011234567"},
+		{raw: "8 701 123 4567", want: "+77011234567"},
+		{raw: "8 (701) 123-45-67", want: "+77011234567"},
+		{raw: "7 701 123 4567", want: "+77011234567"},
+		{raw: "701 123 4567", want: "+77011234567"},
+		{raw: "701.123.4567", want: "+77011234567"},
+		{raw: "  87011234567  ", want: "+77011234567"},
+		{raw: "0077011234567", want: "+77011234567"},
+		{raw: "+44 20 7946 0958", want: "+442079460958"},
+		{raw: "0044 20 7946 0958", want: "+442079460958"},
+		// in international format national numbers can start with 8
+		{raw: "+7 812 123 4567", want: "+78121234567"},
+		{raw: "+7 800 555 3535", want: "+78005553535"},
+		{raw: "+7 870 112 3456", want: "+78701123456"},
+		{raw: "007 800 555 3535", want: "+78005553535"},
+
+		// national numbers never start with the trunk prefix
+		{raw: "8701123456", wantErr: true},
+		{raw: "8 870 112 3456", wantErr: true},
+		{raw: "7 870 112 3456", wantErr: true},
+		{raw: "800 555 3535", wantErr: true},
+		// wrong lengths
+		{raw: "701 123 456", wantErr: true},
+		{raw: "8 701 123 45678", wantErr: true},
+		{raw: "+7 701 123 456", wantErr: true},
+		{raw: "+7 701 123 45678", wantErr: true},
+		{raw: "+1234567", wantErr: true},
+		{raw: "+1234567890123456", wantErr: true},
+		{raw: "+0 701 123 4567", wantErr: true},
+		// not phone numbers
+		{raw: "", wantErr: true},
+		{raw: "+", wantErr: true},
+		{raw: "8 701 abc 4567", wantErr: true},
+		{raw: "++77011234567", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		got, err := Normalize(tt.raw, DefaultRegion)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("Normalize(%q) = %q, want error", tt.raw, got)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("Normalize(%q) = %q, %v, want %q", tt.raw, got, err, tt.want)
+		}
+	}
+}
+
+func TestNormalizeUnsupportedRegion(t *testing.T) {
+	if _, err := Normalize("+77011234567", "XX"); err == nil {
+		t.Error("Normalize with unsupported region succeeded")
+	}
+}
+
+func TestNormalizePrefix(t *testing.T) {
+	tests := []struct {
+		raw    string
+		want   string
+		wantOK bool
+	}{
+		{raw: "+7701", want: "7701", wantOK: true},
+		{raw: "7701", want: "7701", wantOK: true},
+		{raw: "8701", want: "7701", wantOK: true},
+		{raw: "8 (701) 12", want: "770112", wantOK: true},
+		{raw: "+8701", want: "8701", wantOK: true},
+		{raw: " 701 ", want: "701", wantOK: true},
+		{raw: "", wantOK: false},
+		{raw: "+", wantOK: false},
+		{raw: "87a", wantOK: false},
+	}
+
+	for _, tt := range tests {
+		got, ok := NormalizePrefix(tt.raw, DefaultRegion)
+		if ok != tt.wantOK || got != tt.want {
+			t.Errorf("NormalizePrefix(%q) = %q, %v, want %q, %v", tt.raw, got, ok, tt.want, tt.wantOK)
+		}
+	}
+}
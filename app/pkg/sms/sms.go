@@ -0,0 +1,54 @@
+// Package sms has development stubs for sending text messages.
+// Neither of them delivers anything, they make the messages visible to developers instead.
+package sms
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/senizdegen/sdu-housing/user-service/pkg/logging"
+)
+
+// LogSender writes messages to the application log.
+type LogSender struct {
+	Logger logging.Logger
+}
+
+func (s LogSender) Send(ctx context.Context, phoneNumber, text string) error {
+	s.Logger.WithField("to", phoneNumber).Infof("sms: %s", text)
+	return nil
+}
+
+// FileSender appends messages to a file, one per line.
+type FileSender struct {
+	path string
+	mu   sync.Mutex
+}
+
+func NewFileSender(path string) (*FileSender, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create sms directory. error: %w", err)
+	}
+	return &FileSender{path: path}, nil
+}
+
+func (s *FileSender) Send(ctx context.Context, phoneNumber, text string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	file, err := os.OpenFile(s.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to open sms file. error: %w", err)
+	}
+	defer file.Close()
+
+	if _, err = fmt.Fprintf(file, "%s\t%s\t%s\n", time.Now().Format(time.RFC3339), phoneNumber, text); err != nil {
+		return fmt.Errorf("failed to write sms. error: %w", err)
+	}
+
+	return nil
+}